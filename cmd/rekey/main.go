@@ -0,0 +1,94 @@
+/*
+ * Administrative command bringing all snippet content under the current key
+ * of a keyring, for use after rotating in a new key or first configuring one
+ */
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"ptodd.org/snippetbox/pkg/keyring"
+	"ptodd.org/snippetbox/pkg/models/mysql"
+)
+
+// Rotating a key without downtime:
+//
+//  1. Append the new key to the keyring file and restart each instance of the
+//     site with it, one at a time.  Each then encrypts new content under the
+//     new key while still reading content under the old.
+//  2. Run this command with the same keyring.  It works through the content
+//     in small batches alongside the site, rewrapping the data keys of
+//     content under older keys and encrypting any content stored unencrypted.
+//  3. Once a run finds nothing left to change, the old key can be removed
+//     from the file.  Rows in use by the site are skipped until the next
+//     batch, so a run changing any may have missed some.
+//
+// The hash key in the file is not rotated, as content is stored under
+// hashes made with it.
+//
+// Interrupting it is safe; running it again carries on where it left off.
+
+func main() {
+
+	dsn := flag.String("dsn", "web:snippet@/snippetbox?parseTime=true", "MySQL data source name")
+	path := flag.String("keyring", "", "Path to the keyring file, whose last key content is brought under")
+	batch := flag.Int("batch", 100, "Rows of content changed in each transaction")
+	pause := flag.Duration("pause", 100*time.Millisecond, "Pause between batches, to leave the database to the site")
+	flag.Parse()
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if *path == "" || *batch < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	keys, err := keyring.Load(*path)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	db, err := sql.Open("mysql", *dsn)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Stop between batches on an interrupt or termination signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	snippets := &mysql.SnippetModel{DB: db, Keys: keys}
+	infoLog.Printf("Bringing content under key %q", keys.Current())
+	total := 0
+	for {
+		n, err := snippets.Reencrypt(*batch)
+		if err != nil {
+			errorLog.Fatalf("after %d rows: %s", total, err)
+		}
+		total += n
+		if n == 0 {
+			break
+		}
+		infoLog.Printf("%d rows so far", total)
+
+		select {
+		case s := <-quit:
+			infoLog.Printf("Stopping on %s after %d rows; run again to carry on", s, total)
+			return
+		case <-time.After(*pause):
+		}
+	}
+	infoLog.Printf("Done: %d rows brought under key %q", total, keys.Current())
+}
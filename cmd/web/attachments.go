@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"ptodd.org/snippetbox/pkg/blob"
+	"ptodd.org/snippetbox/pkg/forms"
+)
+
+// maxAttachments is the maximum number of files which may be attached to a
+// snippet
+const maxAttachments = 10
+
+// multipartMemory is how much of a multipart form is held in memory while it
+// is parsed; larger uploads are spooled to temporary files
+const multipartMemory = 1 << 20
+
+// attachmentTypes lists the media types of the files which may be attached
+// to snippets, as detected from their content: logs and screenshots along
+// with documents and archives of them
+var attachmentTypes = []string{
+	"text/plain",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"application/zip",
+	"application/x-gzip",
+}
+
+// maxCreateRequest returns the largest create form request accepted: enough
+// for the largest permitted attachments and files plus the rest of the form
+func maxCreateRequest() int64 {
+	return maxAttachments*cfg.maxAttachmentSize + maxFiles*cfg.maxContentSize + 1<<20
+}
+
+// validateAttachments checks the files attached on a create form against the
+// per-file limits and the user's remaining storage, which is their quota less
+// the usage given
+func validateAttachments(form *forms.Form, usage int64) {
+	form.MaxFiles("attachments", maxAttachments)
+	form.MaxFileSize("attachments", cfg.maxAttachmentSize)
+	form.PermittedFileTypes("attachments", attachmentTypes...)
+
+	total := usage
+	for _, fh := range form.Files["attachments"] {
+		total += fh.Size
+	}
+	if total > usage && total > cfg.attachmentQuota {
+		form.Errors.Add("attachments", fmt.Sprintf("These files would take you over your %s of storage (%s used)",
+			forms.FormatSize(cfg.attachmentQuota), forms.FormatSize(usage)))
+	}
+
+	// Attachments would be a way to read a view-limited snippet without
+	// counting a view
+	if len(form.Files["attachments"]) > 0 && maxViews(form) > 0 {
+		form.Errors.Add("attachments", "Files cannot be attached to view-limited snippets")
+	}
+}
+
+// saveAttachments stores the files attached on a validated create form and
+// records them as attachments of the snippet
+func (app *application) saveAttachments(r *http.Request, form *forms.Form, snippetID int) error {
+	userID := app.authenticatedUserID(r)
+	for _, fh := range form.Files["attachments"] {
+		contentType, err := forms.FileType(fh)
+		if err != nil {
+			return err
+		}
+		key, err := blob.NewKey()
+		if err != nil {
+			return err
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		err = app.blobs.Put(key, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		name := fh.Filename
+		if utf8.RuneCountInString(name) > 255 {
+			name = string([]rune(name)[:255])
+		}
+		if _, err = app.attachments.Insert(snippetID, userID, name, contentType, key, fh.Size); err != nil {
+			app.blobs.Delete(key)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"ptodd.org/snippetbox/pkg/forms"
+	syntax "ptodd.org/snippetbox/pkg/highlight"
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+// maxCommentLength is the longest comment which may be posted, in characters
+const maxCommentLength = 2000
+
+// Comments are posted with the fields 'body' and, for replies, 'parent'
+// holding the ID of the comment replied to.  Other comments may be anchored
+// to a range of lines with the fields 'from' and 'to' giving the first and
+// last line numbers, 'to' defaulting to 'from', and 'file' giving the
+// position of the file when the snippet has several.
+
+// codeLine is a line of a file as shown on the page of a snippet, with the
+// comments anchored to lines ending at it
+type codeLine struct {
+	Number   int
+	HTML     string
+	Comments []*models.Comment
+}
+
+// commentList is passed to the template showing a list of comments, which
+// also needs the data of the page it is on
+type commentList struct {
+	Page     *templateData
+	Comments []*models.Comment
+}
+
+// newCommentList returns a list of comments on a page for display
+func newCommentList(page *templateData, comments []*models.Comment) *commentList {
+	return &commentList{Page: page, Comments: comments}
+}
+
+// annotate splits the files of a snippet into lines for display, highlighted
+// as by the syntax template function, attaching each comment anchored to
+// lines of the current revision to the last of them.
+// It returns the lines of each file, nil for those rendered as markdown, and
+// the comments left over, which are shown after the snippet.
+func annotate(s *models.Snippet, comments []*models.Comment) ([][]codeLine, []*models.Comment) {
+	code := make([][]codeLine, len(s.Files))
+	for i, f := range s.Files {
+		if f.Language == "markdown" {
+			continue
+		}
+		for n, line := range syntax.Lines(f.Language, f.Content) {
+			code[i] = append(code[i], codeLine{Number: n + 1, HTML: line})
+		}
+	}
+
+	others := []*models.Comment{}
+	for _, c := range comments {
+		anchored := c.FirstLine > 0 && c.Revision == s.Revision && c.File < len(code) && c.LastLine <= len(code[c.File])
+		if !anchored {
+			others = append(others, c)
+			continue
+		}
+		line := &code[c.File][c.LastLine-1]
+		line.Comments = append(line.Comments, c)
+	}
+	return code, others
+}
+
+// lineCount returns the number of lines of content as split by
+// syntax.Lines
+func lineCount(content string) int {
+	content = strings.TrimSuffix(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	return strings.Count(content, "\n") + 1
+}
+
+// validateComment checks a comment form posted about a snippet with the given
+// files
+func validateComment(form *forms.Form, files []*models.File) {
+	form.Required("body")
+	form.MaxLength("body", maxCommentLength)
+
+	// Replies are shown with the comment they reply to, so are not anchored
+	if form.Get("parent") != "" || (form.Get("from") == "" && form.Get("to") == "") {
+		return
+	}
+
+	positions := make([]string, len(files))
+	for i := range files {
+		positions[i] = strconv.Itoa(i)
+	}
+	form.PermittedValues("file", positions...)
+	form.Required("from")
+	form.PositiveInteger("from")
+	form.PositiveInteger("to")
+	if !form.Valid() {
+		return
+	}
+
+	file, first, last := commentAnchor(form)
+	lines := lineCount(files[file].Content)
+	form.MaxValue("from", lines)
+	form.MaxValue("to", lines)
+	if last < first {
+		form.Errors.Add("to", "This field must not be before the first line")
+	}
+}
+
+// commentAnchor returns the position of the file and the first and last line
+// numbers a validated comment form is anchored to, the line numbers being
+// zero for none
+func commentAnchor(form *forms.Form) (file, first, last int) {
+	if form.Get("parent") != "" || form.Get("from") == "" {
+		return 0, 0, 0
+	}
+	file, _ = strconv.Atoi(form.Get("file"))
+	first, _ = strconv.Atoi(form.Get("from"))
+	last = first
+	if form.Get("to") != "" {
+		last, _ = strconv.Atoi(form.Get("to"))
+	}
+	return file, first, last
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Snippets are embedded in other sites by a script, static/js/embed.js, which
+// replaces the script tag loading it with a frame showing the embed page of
+// the snippet named by its 'data-snippet' attribute.  Only the origins given
+// by the -frame-ancestors flag may frame the embed pages; every other page
+// refuses to be framed at all.
+
+// originList is the list of origins allowed to frame embed pages.  It is a
+// flag.Value so the list can be set from the command line as comma-separated
+// origins, e.g. "https://wiki.example.com,https://*.example.org".
+type originList []string
+
+// String implements flag.Value
+func (l *originList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value
+func (l *originList) Set(s string) error {
+	origins := originList{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if err := checkOrigin(v); err != nil {
+			return err
+		}
+		origins = append(origins, v)
+	}
+	*l = origins
+	return nil
+}
+
+// checkOrigin reports an error unless s is an origin which may appear in a
+// frame-ancestors policy: an http or https scheme and a host, which may start
+// with a '*.' wildcard, and nothing else.  Anything more could change the
+// meaning of the policy it is written into.
+func checkOrigin(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" ||
+		strings.ContainsAny(s, " ;'\"") {
+		return fmt.Errorf("invalid origin %q: want a scheme and host, e.g. https://wiki.example.com", s)
+	}
+	return nil
+}
+
+// siteURL returns the scheme and host the site was requested from, with
+// which absolute links are made for use elsewhere
+func siteURL(r *http.Request) string {
+	return "https://" + r.Host
+}
+
+// embedSnippet handler shows a snippet on its own, without the rest of the
+// site, for display in a frame on another site.  Snippets which could not be
+// shown to the other site's readers are not found, as on their own pages, and
+// views through an embed are not counted.
+func (app *application) embedSnippet(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+
+	files, ok := app.files(w, s)
+	if !ok {
+		return
+	}
+	s.Files = files
+
+	code, _ := annotate(s, nil)
+	app.render(w, r, "embed.page.tmpl", &templateData{
+		Code:    code,
+		Snippet: s,
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOriginList(t *testing.T) {
+
+	var l originList
+	if err := l.Set("https://wiki.example.com, http://localhost:8080,https://*.example.org/"); err != nil {
+		t.Fatal(err)
+	}
+	want := "https://wiki.example.com,http://localhost:8080,https://*.example.org/"
+	if got := l.String(); got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+
+	if err := l.Set(""); err != nil || len(l) != 0 {
+		t.Errorf("want no origins for an empty list; got %v, %v", l, err)
+	}
+
+	for _, bad := range []string{
+		"wiki.example.com",
+		"ftp://wiki.example.com",
+		"https://",
+		"https://wiki.example.com/pages",
+		"https://wiki.example.com?x=1",
+		"https://user@wiki.example.com",
+		"https://wiki.example.com; script-src *",
+		"'self'",
+	} {
+		if err := l.Set(bad); err == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ptodd.org/snippetbox/pkg/forms"
+)
+
+// never is the expiry choice for snippets which do not expire
+const never = "never"
+
+// expiresAtLayout is the format of the explicit expiry date and time chosen
+// on the create form, as submitted by a datetime-local input
+const expiresAtLayout = "2006-01-02T15:04"
+
+// periodUnits are the units an expiry period may be written in.  A year is
+// taken to be 365 days.
+var periodUnits = []struct {
+	suffix   string
+	name     string
+	duration time.Duration
+}{
+	{"m", "minute", time.Minute},
+	{"h", "hour", time.Hour},
+	{"d", "day", 24 * time.Hour},
+	{"w", "week", 7 * 24 * time.Hour},
+	{"y", "year", 365 * 24 * time.Hour},
+}
+
+// parsePeriod parses an expiry period written as a whole number followed by
+// a unit, e.g. "90m" or "2w".  Unlike time.ParseDuration days, weeks and
+// years may be used.  The period's label for display is also returned.
+func parsePeriod(s string) (time.Duration, string, error) {
+	for _, u := range periodUnits {
+		if !strings.HasSuffix(s, u.suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, u.suffix))
+		if err != nil || n < 1 {
+			break
+		}
+		label := fmt.Sprintf("%d %s", n, u.name)
+		if n > 1 {
+			label += "s"
+		}
+		return time.Duration(n) * u.duration, label, nil
+	}
+	return 0, "", fmt.Errorf("invalid period %q: want a whole number of m, h, d, w or y", s)
+}
+
+// expiryChoice is one of the expiry periods offered on the create form
+type expiryChoice struct {
+	Value  string        // as written in configuration and submitted by the form
+	Label  string        // for display
+	Period time.Duration // zero for never
+}
+
+// expiryChoices is the list of expiry periods offered on the create form, the
+// first of which is the default.  It is a flag.Value so the list can be set
+// from the command line as comma-separated periods, e.g. "1d,1w,never".
+type expiryChoices []expiryChoice
+
+// String implements flag.Value
+func (c *expiryChoices) String() string {
+	if c == nil {
+		return ""
+	}
+	values := make([]string, len(*c))
+	for i, choice := range *c {
+		values[i] = choice.Value
+	}
+	return strings.Join(values, ",")
+}
+
+// Set implements flag.Value
+func (c *expiryChoices) Set(s string) error {
+	choices := expiryChoices{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == never {
+			choices = append(choices, expiryChoice{Value: never, Label: "Never"})
+			continue
+		}
+		d, label, err := parsePeriod(v)
+		if err != nil {
+			return err
+		}
+		choices = append(choices, expiryChoice{Value: v, Label: label, Period: d})
+	}
+	*c = choices
+	return nil
+}
+
+// values returns the form values of the choices
+func (c expiryChoices) values() []string {
+	values := make([]string, len(c))
+	for i, choice := range c {
+		values[i] = choice.Value
+	}
+	return values
+}
+
+// get returns the choice with the given form value
+func (c expiryChoices) get(value string) (expiryChoice, bool) {
+	for _, choice := range c {
+		if choice.Value == value {
+			return choice, true
+		}
+	}
+	return expiryChoice{}, false
+}
+
+// lifetime is the longest a snippet may be kept for, or zero for no limit.
+// It is a flag.Value written as a period (c.f. parsePeriod) or "0".
+type lifetime time.Duration
+
+// String implements flag.Value
+func (l *lifetime) String() string {
+	if l == nil || *l == 0 {
+		return "0"
+	}
+	for i := len(periodUnits) - 1; i >= 0; i-- {
+		u := periodUnits[i]
+		if time.Duration(*l)%u.duration == 0 {
+			return strconv.Itoa(int(time.Duration(*l)/u.duration)) + u.suffix
+		}
+	}
+	return time.Duration(*l).String()
+}
+
+// Set implements flag.Value
+func (l *lifetime) Set(s string) error {
+	if s == "0" {
+		*l = 0
+		return nil
+	}
+	d, _, err := parsePeriod(s)
+	if err != nil {
+		return err
+	}
+	*l = lifetime(d)
+	return nil
+}
+
+// checkExpiryConfig reports whether every expiry choice is within the
+// maximum lifetime
+func checkExpiryConfig(choices expiryChoices, max lifetime) error {
+	if len(choices) == 0 {
+		return errors.New("at least one expiry choice is required")
+	}
+	if max == 0 {
+		return nil
+	}
+	for _, c := range choices {
+		if c.Period == 0 || c.Period > time.Duration(max) {
+			return fmt.Errorf("expiry choice %q exceeds the maximum lifetime of %s", c.Value, max.String())
+		}
+	}
+	return nil
+}
+
+// expiryTime validates the expiry chosen on the create form, which is either
+// one of the configured choices or "date" for the explicit date and time
+// given by 'expires_at' (in UTC), and returns the time the snippet should
+// expire.  The zero time means never.
+func expiryTime(form *forms.Form, choices expiryChoices, max lifetime, now time.Time) time.Time {
+	form.Required("expires")
+	form.PermittedValues("expires", append(choices.values(), "date")...)
+	if form.Get("expires") != "date" {
+		choice, ok := choices.get(form.Get("expires"))
+		if !ok || choice.Period == 0 {
+			return time.Time{}
+		}
+		return now.Add(choice.Period)
+	}
+
+	form.Required("expires_at")
+	form.ValidDate("expires_at", expiresAtLayout)
+	if form.Errors.Get("expires_at") != "" {
+		return time.Time{}
+	}
+	t, _ := time.Parse(expiresAtLayout, form.Get("expires_at"))
+	switch {
+	case !t.After(now):
+		form.Errors.Add("expires_at", "This field must be in the future")
+	case max != 0 && t.Sub(now) > time.Duration(max):
+		form.Errors.Add("expires_at", fmt.Sprintf("This field must be within %s", max.String()))
+	}
+	return t
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"ptodd.org/snippetbox/pkg/forms"
+)
+
+func TestExpiryChoices(t *testing.T) {
+
+	var c expiryChoices
+	if err := c.Set("30m, 1h,2d,1w,3y,never"); err != nil {
+		t.Fatal(err)
+	}
+	want := []expiryChoice{
+		{"30m", "30 minutes", 30 * time.Minute},
+		{"1h", "1 hour", time.Hour},
+		{"2d", "2 days", 48 * time.Hour},
+		{"1w", "1 week", 7 * 24 * time.Hour},
+		{"3y", "3 years", 3 * 365 * 24 * time.Hour},
+		{"never", "Never", 0},
+	}
+	if len(c) != len(want) {
+		t.Fatalf("want %d choices; got %d", len(want), len(c))
+	}
+	for i := range want {
+		if c[i] != want[i] {
+			t.Errorf("want %v; got %v", want[i], c[i])
+		}
+	}
+
+	for _, bad := range []string{"", "1", "0d", "-1h", "1.5h", "1s", "forever"} {
+		if err := c.Set(bad); err == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+
+	var l lifetime
+	for _, s := range []string{"0", "90m", "2w", "1y"} {
+		if err := l.Set(s); err != nil {
+			t.Fatal(err)
+		}
+		if got := l.String(); got != s {
+			t.Errorf("want %q; got %q", s, got)
+		}
+	}
+	l.Set("1w")
+	c.Set("1d,never")
+	if err := checkExpiryConfig(c, l); err == nil {
+		t.Errorf("want error for never with a maximum lifetime")
+	}
+}
+
+func TestExpiryTime(t *testing.T) {
+
+	var choices expiryChoices
+	choices.Set("1h,1w,never")
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		expires   string
+		expiresAt string
+		max       lifetime
+		want      time.Time
+		wantError string
+	}{
+		{"Choice", "1w", "", 0, now.Add(7 * 24 * time.Hour), ""},
+		{"Never", "never", "", 0, time.Time{}, ""},
+		{"Not offered", "2w", "", 0, time.Time{}, "expires"},
+		{"Missing", "", "", 0, time.Time{}, "expires"},
+		{"Date", "date", "2020-01-02T09:30", 0, time.Date(2020, 1, 2, 9, 30, 0, 0, time.UTC), ""},
+		{"Date in the past", "date", "2019-12-31T09:30", 0, time.Date(2019, 12, 31, 9, 30, 0, 0, time.UTC), "expires_at"},
+		{"Date beyond maximum", "date", "2020-01-09T12:00", lifetime(7 * 24 * time.Hour), time.Date(2020, 1, 9, 12, 0, 0, 0, time.UTC), "expires_at"},
+		{"Malformed date", "date", "tomorrow", 0, time.Time{}, "expires_at"},
+		{"Missing date", "date", "", 0, time.Time{}, "expires_at"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := forms.New(url.Values{"expires": {tt.expires}, "expires_at": {tt.expiresAt}})
+			got := expiryTime(form, choices, tt.max, now)
+			if !got.Equal(tt.want) {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+			for _, field := range []string{"expires", "expires_at"} {
+				if hasError := form.Errors.Get(field) != ""; hasError != (field == tt.wantError) {
+					t.Errorf("want error on %q only; got %v", tt.wantError, form.Errors)
+				}
+			}
+		})
+	}
+}
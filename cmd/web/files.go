@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"ptodd.org/snippetbox/pkg/diff"
+	"ptodd.org/snippetbox/pkg/forms"
+	syntax "ptodd.org/snippetbox/pkg/highlight"
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+// maxFiles is the maximum number of files a snippet may have
+const maxFiles = 10
+
+// maxEditRequest returns the largest edit form request accepted: enough for
+// the largest permitted files plus the rest of the form
+func maxEditRequest() int64 {
+	return maxFiles*cfg.maxContentSize + 1<<20
+}
+
+// The files of a snippet are submitted on the create and edit forms as the
+// repeated fields 'filename', 'language' and 'content', the i-th value of each
+// describing the i-th file.  Without any client-side script files are added
+// and removed by submitting the form with the 'action' field set to
+// "add-file" or the 'remove' field set to the index of the file to remove,
+// which redisplays the form rather than saving the snippet.
+
+// formFiles returns the files described by a create or edit form, of which
+// there is always at least one.  Missing values are left blank.
+func formFiles(form *forms.Form) []*models.File {
+	names, languages, contents := form.Entries("filename"), form.Entries("language"), form.Entries("content")
+
+	n := 1
+	for _, entries := range [][]string{names, languages, contents} {
+		if len(entries) > n {
+			n = len(entries)
+		}
+	}
+
+	files := make([]*models.File, n)
+	for i := range files {
+		files[i] = &models.File{
+			Name:     entry(names, i),
+			Language: entry(languages, i),
+			Content:  entry(contents, i),
+		}
+	}
+	return files
+}
+
+// entry returns the value at an index of a repeated field, or blank if there
+// are fewer values
+func entry(entries []string, i int) string {
+	if i < len(entries) {
+		return entries[i]
+	}
+	return ""
+}
+
+// setFormFiles replaces the files described by a create or edit form
+func setFormFiles(form *forms.Form, files []*models.File) {
+	names := make([]string, len(files))
+	languages := make([]string, len(files))
+	contents := make([]string, len(files))
+	for i, f := range files {
+		names[i], languages[i], contents[i] = f.Name, f.Language, f.Content
+	}
+	form.Values["filename"] = names
+	form.Values["language"] = languages
+	form.Values["content"] = contents
+}
+
+// editFiles adds or removes a file as requested by a create or edit form
+// submission, reporting whether it did so in which case the form should be
+// redisplayed
+func editFiles(form *forms.Form) bool {
+	files := formFiles(form)
+
+	switch {
+	case form.Get("action") == "add-file":
+		if len(files) >= maxFiles {
+			form.Errors.Add("content", fmt.Sprintf("This field has too many entries (maximum is %d)", maxFiles))
+			return true
+		}
+		files = append(files, &models.File{})
+
+	case form.Get("remove") != "":
+		i, err := strconv.Atoi(form.Get("remove"))
+		if err == nil && i >= 0 && i < len(files) && len(files) > 1 {
+			files = append(files[:i], files[i+1:]...)
+		}
+
+	default:
+		return false
+	}
+
+	setFormFiles(form, files)
+	return true
+}
+
+// validateFiles checks the files described by a create or edit form.  Every
+// file needs content, and when there is more than one each needs a distinct
+// name.
+func validateFiles(form *forms.Form) {
+	setFormFiles(form, formFiles(form))
+
+	form.RequiredEntries("content")
+	form.MaxEntries("content", maxFiles)
+	form.MaxSizeEntries("content", cfg.maxContentSize)
+	form.PermittedEntries("language", syntax.Names()...)
+	form.MaxLengthEntries("filename", 100)
+	form.EntriesMatchPattern("filename", forms.FilenameRX)
+	form.UniqueEntries("filename")
+	if len(form.Entries("content")) > 1 {
+		form.RequiredEntries("filename")
+	}
+}
+
+// submittedFiles returns the files described by a validated create or edit
+// form with the default language filled in
+func submittedFiles(form *forms.Form) []*models.File {
+	files := formFiles(form)
+	for _, f := range files {
+		if f.Language == "" {
+			f.Language = syntax.Text
+		}
+	}
+	return files
+}
+
+// fileDiff describes the changes to one file between two revisions of a
+// snippet.  From is nil for a file that was added and To for one that was
+// removed.
+type fileDiff struct {
+	From  *models.File
+	To    *models.File
+	Hunks []diff.Hunk
+}
+
+// diffFiles pairs up the files of two revisions of a snippet by name and
+// returns the changes to those that differ, in the order of the later
+// revision followed by any files it removed.  A snippet of a single file is
+// compared regardless of whether it was renamed.
+func diffFiles(from, to []*models.File) []*fileDiff {
+	var diffs []*fileDiff
+	add := func(a, b *models.File) {
+		var before, after string
+		if a != nil {
+			before = a.Content
+		}
+		if b != nil {
+			after = b.Content
+		}
+		d := &fileDiff{From: a, To: b, Hunks: diff.Hunks(before, after, 3)}
+		if len(d.Hunks) > 0 || a == nil || b == nil || a.Name != b.Name || a.Language != b.Language {
+			diffs = append(diffs, d)
+		}
+	}
+
+	if len(from) == 1 && len(to) == 1 {
+		add(from[0], to[0])
+		return diffs
+	}
+
+	named := make(map[string]*models.File, len(from))
+	for _, f := range from {
+		named[f.Name] = f
+	}
+	for _, f := range to {
+		add(named[f.Name], f)
+		delete(named, f.Name)
+	}
+	for _, f := range from {
+		if named[f.Name] != nil {
+			add(f, nil)
+		}
+	}
+	return diffs
+}
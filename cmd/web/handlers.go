@@ -1,42 +1,424 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"ptodd.org/snippetbox/pkg/forms"
+	syntax "ptodd.org/snippetbox/pkg/highlight"
 	"ptodd.org/snippetbox/pkg/models"
 )
 
-// Home page handler
+// Home page handler lists the latest snippets a page at a time using the
+// 'before' and 'after' query string cursors
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
-	s, err := app.snippets.Latest()
+	page, err := pageRequest(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.snippets.Latest(page)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
+	s, p := pageLinks(r, page, s)
 	app.render(w, r, "home.page.tmpl", &templateData{
-		Snippets: s,
+		Pagination: p,
+		Snippets:   s,
 	})
 }
 
-// showSnippet handler
+// showSnippet handler displays a snippet named by its ID or, for sharing
+// unlisted snippets, its slug
 func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
 
-	// Extract expected 'id' parameter from query string
-	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	// Retrieve the snippet named by the URL or respond with a 404
+	s, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// Retrieve everything else on the page while the snippet still exists
+	td, ok := app.snippetPage(w, r, s)
+	if !ok {
+		return
+	}
+
+	s, ok = app.countView(w, r, s)
+	if !ok {
+		return
+	}
+	s.Tags, s.Files = td.Snippet.Tags, td.Snippet.Files
+	td.Snippet = s
+
+	// Views of snippets without a view limit are counted in memory and
+	// written later, once per session within the view window and never the
+	// owner's, so the total shown includes those yet to be written
+	if s.MaxViews == 0 && s.UserID != app.authenticatedUserID(r) && app.firstView(r, s.ID, time.Now()) {
+		app.views.add(s.ID)
+	}
+	td.Views = s.Views + app.views.pending(s.ID)
+
+	// Advertise the page to oEmbed consumers if they could see it
+	if s.MaxViews == 0 && s.Visibility != models.Private {
+		td.OEmbedURL = siteURL(r) + snippetPath(s)
+	}
+
+	// Render the template passing the snippet
+	td.Form = forms.New(nil)
+	app.render(w, r, "show.page.tmpl", td)
+}
+
+// snippetPage helper retrieves the data shown on the page of a snippet along
+// with the snippet itself: its tags and files, lineage, attachments, stars
+// and comments.  The caller should render it unless a response has already
+// been sent.
+func (app *application) snippetPage(w http.ResponseWriter, r *http.Request, s *models.Snippet) (*templateData, bool) {
+
+	tags, err := app.tags.ForSnippet(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+	files, ok := app.files(w, s)
+	if !ok {
+		return nil, false
+	}
+	s.Tags = tags
+	s.Files = files
+
+	// Retrieve the snippet's lineage: the snippet it was forked from, if the
+	// user could find it by ID, and the forks the user is allowed to see
+	var parent *models.Snippet
+	if s.ParentID != 0 {
+		parent, err = app.snippets.Get(s.ParentID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return nil, false
+		}
+		if parent != nil && parent.Visibility != models.Public && parent.UserID != app.authenticatedUserID(r) {
+			parent = nil
+		}
+	}
+	forks, err := app.snippets.Forks(s.ID, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+	identical, err := app.snippets.Identical(s.ID, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+	attachments, err := app.attachments.ForSnippet(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+	starred := false
+	if app.isAuthenticated(r) {
+		starred, err = app.stars.Has(app.authenticatedUserID(r), s.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return nil, false
+		}
+	}
+	comments, err := app.comments.ForSnippet(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+	code, comments := annotate(s, comments)
+
+	// Split the user's collections into those the snippet is in and those
+	// it could be added to
+	var collections, more []*models.Collection
+	if app.isAuthenticated(r) {
+		collections, err = app.collections.ForSnippet(s.ID, app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, err)
+			return nil, false
+		}
+		all, err := app.collections.ByUser(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, err)
+			return nil, false
+		}
+		in := map[int]bool{}
+		for _, c := range collections {
+			in[c.ID] = true
+		}
+		for _, c := range all {
+			if !in[c.ID] {
+				more = append(more, c)
+			}
+		}
+	}
+
+	return &templateData{
+		Attachments:     attachments,
+		Code:            code,
+		Collections:     collections,
+		Comments:        comments,
+		Forks:           forks,
+		Identical:       identical,
+		MoreCollections: more,
+		Parent:          parent,
+		Snippet:         s,
+		Starred:         starred,
+	}, true
+}
+
+// postComment handler adds a comment, or a reply to one, to the snippet named
+// by the URL on behalf of the current user
+func (app *application) postComment(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	files, ok := app.files(w, s)
+	if !ok {
+		return
+	}
+	form := forms.New(r.PostForm)
+	validateComment(form, files)
+
+	// Replies must be to a comment on the same snippet which is not itself a
+	// reply
+	parentID := 0
+	if form.Get("parent") != "" {
+		id, err := strconv.Atoi(form.Get("parent"))
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		parent, err := app.comments.Get(id)
+		if err != nil && errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if parent.SnippetID != s.ID || parent.ParentID != 0 {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		parentID = parent.ID
+	}
+
+	// Redisplay the page with the form's errors
+	if !form.Valid() {
+		td, ok := app.snippetPage(w, r, s)
+		if !ok {
+			return
+		}
+		td.Form = form
+		td.Views = s.Views + app.views.pending(s.ID)
+		app.render(w, r, "show.page.tmpl", td)
+		return
+	}
+
+	c := &models.Comment{
+		SnippetID: s.ID,
+		UserID:    app.authenticatedUserID(r),
+		ParentID:  parentID,
+		Revision:  s.Revision,
+		Body:      form.Get("body"),
+	}
+	c.File, c.FirstLine, c.LastLine = commentAnchor(form)
+	id, err := app.comments.Insert(c)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%s#comment-%d", snippetPath(s), id), http.StatusSeeOther)
+}
+
+// deleteComment handler removes a comment from the snippet named by the URL,
+// along with any replies to it.  Comments may be deleted by their author and
+// by the owner of the snippet.
+func (app *application) deleteComment(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := intParam(r, ":cid", 0)
 	if err != nil || id < 1 {
 		app.notFound(w)
 		return
 	}
+	c, err := app.comments.Get(id)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if c.SnippetID != s.ID {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	if userID != c.UserID && userID != s.UserID {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.comments.Delete(c.ID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Comment deleted.")
+
+	http.Redirect(w, r, snippetPath(s)+"#comments", http.StatusSeeOther)
+}
+
+// rawSnippet handler serves the content of a snippet as plain text, e.g. for
+// piping into a shell
+func (app *application) rawSnippet(w http.ResponseWriter, r *http.Request) {
+	app.serveContent(w, r, "inline")
+}
+
+// downloadSnippet handler serves the content of a snippet as a file named
+// after its title and language
+func (app *application) downloadSnippet(w http.ResponseWriter, r *http.Request) {
+	app.serveContent(w, r, "attachment")
+}
+
+// serveContent writes the content of the snippet named by the URL as plain
+// text with the given disposition.  The headers stop browsers sniffing the
+// content as anything else or running anything in it should it be opened.
+func (app *application) serveContent(w http.ResponseWriter, r *http.Request, disposition string) {
+
+	s, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+	s, ok = app.countView(w, r, s)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": filename(s),
+	}))
+
+	// A view-limited snippet may have been destroyed by counting the view,
+	// so its content comes with the count.  Any other is written as it is
+	// decompressed rather than first being built into a string.
+	if s.MaxViews > 0 && s.UserID != app.authenticatedUserID(r) {
+		io.WriteString(w, s.Content)
+		return
+	}
+	err := app.snippets.WriteContent(s.ID, w)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+	}
+}
+
+// zipSnippet handler serves every file of a snippet bundled into a zip
+// archive named after its title
+func (app *application) zipSnippet(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+	files, ok := app.files(w, s)
+	if !ok {
+		return
+	}
+	s, ok = app.countView(w, r, s)
+	if !ok {
+		return
+	}
+
+	// Build the archive in a buffer to trap any errors before responding
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		name := f.Name
+		if name == "" {
+			name = filename(s)
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: s.Created})
+		if err == nil {
+			_, err = io.WriteString(fw, f.Content)
+		}
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": basename(s) + ".zip",
+	}))
+	buf.WriteTo(w)
+}
+
+// showAttachment handler serves a file attached to a snippet to a logged in
+// user who may see the snippet, so only to its owner if it is private.  Like
+// raw content it is sent with headers which stop browsers sniffing it as
+// anything else or running anything in it.
+func (app *application) showAttachment(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
 
-	// Use the model's get method to receive a record based upon its ID then
-	// return the record or a 404
-	s, err := app.snippets.Get(id)
+	id, err := intParam(r, ":aid", 0)
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+	a, err := app.attachments.Get(id)
 	if err != nil && errors.Is(err, models.ErrNoRecord) {
 		app.notFound(w)
 		return
@@ -45,28 +427,212 @@ func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
 		app.serverError(w, err)
 		return
 	}
+	if a.SnippetID != s.ID {
+		app.notFound(w)
+		return
+	}
+
+	rc, err := app.blobs.Open(a.Key)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	defer rc.Close()
 
-	// Render the template passing the snippet
-	app.render(w, r, "show.page.tmpl", &templateData{
-		Snippet: s,
+	// Images and text are shown in the browser, anything else downloaded
+	disposition := "attachment"
+	if strings.HasPrefix(a.ContentType, "image/") || strings.HasPrefix(a.ContentType, "text/plain") {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": a.Name,
+	}))
+	io.Copy(w, rc)
+}
+
+// filenameRX matches the runs of characters replaced when making a filename
+var filenameRX = regexp.MustCompile(`[^a-z0-9]+`)
+
+// filename returns a safe name for a file containing the first file of a
+// snippet: its own name if it has one, or else one derived from the
+// snippet's title with an extension for its language
+func filename(s *models.Snippet) string {
+	if s.Filename != "" {
+		return s.Filename
+	}
+	return basename(s) + syntax.Extension(s.Language)
+}
+
+// basename returns a safe name without any extension for files containing a
+// snippet, derived from its title
+func basename(s *models.Snippet) string {
+	name := strings.Trim(filenameRX.ReplaceAllString(strings.ToLower(s.Title), "-"), "-")
+	if len(name) > 50 {
+		name = strings.TrimRight(name[:50], "-")
+	}
+	if name == "" {
+		name = fmt.Sprintf("snippet-%d", s.ID)
+	}
+	return name
+}
+
+// searchPageSize is the number of results shown on each page of a search
+const searchPageSize = 10
+
+// search handler performs a full-text search of snippets using the 'q' query
+// string parameter, optionally filtered by owner and creation date
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+
+	// Validate the search criteria
+	form := forms.New(r.URL.Query())
+	form.MaxLength("q", 200)
+	form.PositiveInteger("owner")
+	form.PositiveInteger("page")
+	form.ValidDate("from", "2006-01-02")
+	form.ValidDate("to", "2006-01-02")
+
+	// Without any terms (or with invalid criteria) just display the form.
+	// Encrypted content cannot be searched, which the form points out.
+	td := &templateData{Form: form, TitlesOnly: cfg.keyring != ""}
+	if strings.TrimSpace(form.Get("q")) == "" || !form.Valid() {
+		app.render(w, r, "search.page.tmpl", td)
+		return
+	}
+
+	// The criteria have already been validated so parsing cannot fail.  The
+	// 'to' date is inclusive so searches run up to the start of the next day.
+	page, _ := intParam(r, "page", 1)
+	q := models.SearchQuery{
+		Terms:    form.Get("q"),
+		ViewerID: app.authenticatedUserID(r),
+		Offset:   (page - 1) * searchPageSize,
+		Limit:    searchPageSize + 1,
+	}
+	q.UserID, _ = intParam(r, "owner", 0)
+	if v := form.Get("from"); v != "" {
+		q.CreatedAfter, _ = time.Parse("2006-01-02", v)
+	}
+	if v := form.Get("to"); v != "" {
+		to, _ := time.Parse("2006-01-02", v)
+		q.CreatedBefore = to.AddDate(0, 0, 1)
+	}
+
+	// One more result than is displayed is requested to find out whether
+	// there is a further page
+	s, err := app.snippets.Search(q)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	td.Pagination = &pagination{}
+	if len(s) > searchPageSize {
+		s = s[:searchPageSize]
+		td.Pagination.Next = pageURL(r, page+1)
+	}
+	if page > 1 {
+		td.Pagination.Prev = pageURL(r, page-1)
+	}
+	td.Snippets = s
+
+	app.render(w, r, "search.page.tmpl", td)
+}
+
+// maxTags is the maximum number of tags a snippet may have
+const maxTags = 10
+
+// maxViewLimit is the largest number of views a snippet may be limited to
+const maxViewLimit = 1000
+
+// tagSnippets handler lists a page of the snippets with the tag named by the
+// URL
+func (app *application) tagSnippets(w http.ResponseWriter, r *http.Request) {
+
+	tag := r.URL.Query().Get(":name")
+	if !forms.TagRX.MatchString(tag) {
+		app.notFound(w)
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.snippets.ByTag(tag, page)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, p := pageLinks(r, page, s)
+	app.render(w, r, "tag.page.tmpl", &templateData{
+		Pagination: p,
+		Snippets:   s,
+		Tag:        strings.ToLower(tag),
 	})
 }
 
 // createSnippet handler
 func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 
-	// Add any data in POST (works for PUT and PATCH) bodies to the r.PostForm map
-	err := r.ParseForm()
-	if err != nil {
+	// Add any data in POST bodies to the r.PostForm map.  The form is sent
+	// as multipart data when files are attached.
+	err := r.ParseMultipartForm(multipartMemory)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
+	form := forms.New(r.PostForm)
+	if r.MultipartForm != nil {
+		form.Files = r.MultipartForm.File
+	}
+
+	// Adding or removing a file just redisplays the form
+	if editFiles(form) {
+		app.render(w, r, "create.page.tmpl", &templateData{
+			Form: form,
+		})
+		return
+	}
 
 	// Retrieve and validate relevant data fields
-	form := forms.New(r.PostForm)
-	form.Required("title", "content", "expires")
+	form.Required("title")
 	form.MaxLength("title", 100)
-	form.PermittedValues("expires", "1", "7", "365")
+	validateFiles(form)
+	expires := expiryTime(form, cfg.expiryChoices, cfg.maxLifetime, time.Now())
+	form.PermittedValues("visibility", models.Visibilities...)
+	form.PositiveInteger("max_views")
+	form.MaxValue("max_views", maxViewLimit)
+	form.MaxItems("tags", maxTags)
+	form.ItemsMatchPattern("tags", forms.TagRX)
+	if len(form.Files["attachments"]) > 0 {
+		usage, err := app.attachments.Usage(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		validateAttachments(form, usage)
+	}
+
+	// A fork records the snippet it was copied from, if that can still be
+	// seen; otherwise it is kept as a snippet in its own right
+	parentID := 0
+	if slug := form.Get("fork"); slug != "" {
+		parent, err := app.forkSource(r, slug)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+		if err == nil {
+			parentID = parent.ID
+		}
+	}
 
 	// Handle errors if any were encountered
 	// If there are any errors, re-display the template passing to it the
@@ -78,12 +644,24 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Insert the record through our model and receive back the ID of the new record
-	id, err := app.snippets.Insert(form.Get("title"), form.Get("content"), form.Get("expires"))
+	// Insert the record through our model, owned by the current user, and
+	// receive back the ID of the new record
+	id, err := app.snippets.Insert(app.authenticatedUserID(r), form.Get("title"), submittedFiles(form), visibility(form), expires, maxViews(form), parentID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Tag the new snippet and store its attachments
+	err = app.tags.Set(id, form.GetList("tags"))
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
+	if err = app.saveAttachments(r, form, id); err != nil {
+		app.serverError(w, err)
+		return
+	}
 
 	// Add a flash confirmation to the user session
 	app.session.Put(r, "flash", "Snippet successfully created!")
@@ -91,10 +669,586 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
 }
 
-// createSnippetForm handler
-func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "create.page.tmpl", &templateData{
-		Form: forms.New(nil),
+// maxViews returns the view limit chosen on a validated form, which defaults
+// to zero for no limit
+func maxViews(form *forms.Form) int {
+	n, _ := strconv.Atoi(form.Get("max_views"))
+	return n
+}
+
+// visibility returns the snippet visibility chosen on a validated form, which
+// defaults to public
+func visibility(form *forms.Form) string {
+	if v := form.Get("visibility"); v != "" {
+		return v
+	}
+	return models.Public
+}
+
+// validateCollection checks a submitted collection form
+func validateCollection(form *forms.Form) {
+	form.Required("name")
+	form.MaxLength("name", 100)
+	form.PermittedValues("visibility", models.CollectionVisibilities...)
+}
+
+// collectionVisibility returns the collection visibility chosen on a
+// validated form, which defaults to private
+func collectionVisibility(form *forms.Form) string {
+	if v := form.Get("visibility"); v != "" {
+		return v
+	}
+	return models.Private
+}
+
+// createSnippetForm handler displays an empty form, or when the 'fork' query
+// string parameter gives the slug of a snippet, a form pre-filled with a copy
+// of it
+func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
+
+	slug := r.URL.Query().Get("fork")
+	if slug == "" {
+		app.render(w, r, "create.page.tmpl", &templateData{
+			Form: forms.New(nil),
+		})
+		return
+	}
+
+	s, err := app.forkSource(r, slug)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	tags, err := app.tags.ForSnippet(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	files, ok := app.files(w, s)
+	if !ok {
+		return
+	}
+
+	form := forms.New(url.Values{
+		"title":      []string{s.Title},
+		"visibility": []string{s.Visibility},
+		"tags":       []string{strings.Join(tags, ", ")},
+		"fork":       []string{slug},
+	})
+	setFormFiles(form, files)
+	app.render(w, r, "create.page.tmpl", &templateData{
+		Form: form,
+	})
+}
+
+// editSnippetForm handler displays the edit form pre-filled with the current
+// title and files of a snippet owned by the current user
+func (app *application) editSnippetForm(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	tags, err := app.tags.ForSnippet(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	files, ok := app.files(w, s)
+	if !ok {
+		return
+	}
+
+	form := forms.New(url.Values{
+		"title":      []string{s.Title},
+		"visibility": []string{s.Visibility},
+		"tags":       []string{strings.Join(tags, ", ")},
+	})
+	setFormFiles(form, files)
+	app.render(w, r, "edit.page.tmpl", &templateData{
+		Form:    form,
+		Snippet: s,
+	})
+}
+
+// editSnippet handler saves changes to a snippet owned by the current user as
+// a new revision
+func (app *application) editSnippet(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	// Adding or removing a file just redisplays the form
+	if editFiles(form) {
+		app.render(w, r, "edit.page.tmpl", &templateData{
+			Form:    form,
+			Snippet: s,
+		})
+		return
+	}
+
+	// Retrieve and validate relevant data fields
+	form.Required("title")
+	form.MaxLength("title", 100)
+	validateFiles(form)
+	form.PermittedValues("visibility", models.Visibilities...)
+	form.MaxItems("tags", maxTags)
+	form.ItemsMatchPattern("tags", forms.TagRX)
+
+	if !form.Valid() {
+		app.render(w, r, "edit.page.tmpl", &templateData{
+			Form:    form,
+			Snippet: s,
+		})
+		return
+	}
+
+	// Save the changes.  The snippet may have expired since it was retrieved.
+	err = app.snippets.Update(s.ID, app.authenticatedUserID(r), form.Get("title"), submittedFiles(form), visibility(form))
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.tags.Set(s.ID, form.GetList("tags"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Snippet successfully updated!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", s.ID), http.StatusSeeOther)
+}
+
+// snippetHistory handler lists every revision of a snippet
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+
+	revs, err := app.snippets.Revisions(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "history.page.tmpl", &templateData{
+		Revisions: revs,
+		Snippet:   s,
+	})
+}
+
+// snippetDiff handler shows a unified diff between two revisions of a
+// snippet given by the 'from' and 'to' query string parameters.  By default
+// the latest revision is compared with the one before it.
+func (app *application) snippetDiff(w http.ResponseWriter, r *http.Request) {
+
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+
+	// Retrieve the revision numbers to compare, defaulting any that are absent
+	to, err := intParam(r, "to", s.Revision)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	from, err := intParam(r, "from", to-1)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Look up both revisions, treating unknown revision numbers as not found
+	var revs [2]*models.Revision
+	for i, n := range []int{from, to} {
+		revs[i], err = app.snippets.Revision(s.ID, n)
+		if err != nil && errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+			return
+		}
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.render(w, r, "diff.page.tmpl", &templateData{
+		Diffs:        diffFiles(revs[0].Files, revs[1].Files),
+		FromRevision: revs[0],
+		Snippet:      s,
+		ToRevision:   revs[1],
+	})
+}
+
+// deleteSnippet handler moves a snippet owned by the current user into the
+// trash so it is immediately hidden from everyone
+func (app *application) deleteSnippet(w http.ResponseWriter, r *http.Request) {
+	app.trashAction(w, r, app.snippets.Delete, "Snippet moved to the trash.", "/user/snippets")
+}
+
+// restoreSnippet handler takes a snippet owned by the current user back out of
+// the trash
+func (app *application) restoreSnippet(w http.ResponseWriter, r *http.Request) {
+	app.trashAction(w, r, app.snippets.Restore, "Snippet restored.", "/user/trash")
+}
+
+// purgeSnippet handler permanently removes a trashed snippet owned by the
+// current user
+func (app *application) purgeSnippet(w http.ResponseWriter, r *http.Request) {
+	app.trashAction(w, r, app.snippets.Purge, "Snippet permanently deleted.", "/user/trash")
+}
+
+// trashAction applies one of the trash model operations to the snippet named
+// by the URL on behalf of the current user, then redirects with a flash
+// message.  Snippets which do not exist or belong to someone else are treated
+// as not found.
+func (app *application) trashAction(w http.ResponseWriter, r *http.Request, action func(int, int) error, flash, redirect string) {
+
+	id, ok := snippetID(r)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	err := action(id, app.authenticatedUserID(r))
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", flash)
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// starSnippet handler stars the snippet named by the URL for the current user
+func (app *application) starSnippet(w http.ResponseWriter, r *http.Request) {
+	app.starAction(w, r, app.stars.Add)
+}
+
+// unstarSnippet handler removes the current user's star from the snippet
+// named by the URL
+func (app *application) unstarSnippet(w http.ResponseWriter, r *http.Request) {
+	app.starAction(w, r, app.stars.Remove)
+}
+
+// starAction applies one of the star model operations to the snippet named by
+// the URL on behalf of the current user, then redirects back to the snippet.
+// Only snippets the user can see may be starred.
+func (app *application) starAction(w http.ResponseWriter, r *http.Request, action func(int, int) error) {
+
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := action(app.authenticatedUserID(r), s.ID)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, snippetPath(s), http.StatusSeeOther)
+}
+
+// collectSnippet handler adds the snippet named by the URL to the current
+// user's collection named by the 'collection' form field
+func (app *application) collectSnippet(w http.ResponseWriter, r *http.Request) {
+	app.collectAction(w, r, app.collections.Add, "Snippet added to %s.")
+}
+
+// uncollectSnippet handler removes the snippet named by the URL from the
+// current user's collection named by the 'collection' form field
+func (app *application) uncollectSnippet(w http.ResponseWriter, r *http.Request) {
+	app.collectAction(w, r, app.collections.Remove, "Snippet removed from %s.")
+}
+
+// collectAction applies one of the collection model's operations on snippets
+// to the snippet named by the URL and a collection owned by the current user,
+// then redirects back to the snippet with a flash message naming the
+// collection.  Only snippets the user can see may be collected.
+func (app *application) collectAction(w http.ResponseWriter, r *http.Request, action func(int, int) error, flash string) {
+
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("collection"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	c, err := app.collections.Get(id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+	if c == nil || c.UserID != app.authenticatedUserID(r) {
+		app.notFound(w)
+		return
+	}
+
+	err = action(c.ID, s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf(flash, c.Name))
+
+	http.Redirect(w, r, snippetPath(s), http.StatusSeeOther)
+}
+
+// userCollections handler lists the current user's collections
+func (app *application) userCollections(w http.ResponseWriter, r *http.Request) {
+
+	c, err := app.collections.ByUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "collections.page.tmpl", &templateData{
+		Collections: c,
+	})
+}
+
+// showCollection handler lists the snippets in a collection named by its ID
+// or, for shared collections, its slug
+func (app *application) showCollection(w http.ResponseWriter, r *http.Request) {
+
+	c, ok := app.collection(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.snippets.InCollection(c.ID, app.authenticatedUserID(r), page)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, p := pageLinks(r, page, s)
+	app.render(w, r, "collection.page.tmpl", &templateData{
+		Collection: c,
+		Pagination: p,
+		Snippets:   s,
+	})
+}
+
+// createCollectionForm handler displays an empty collection form
+func (app *application) createCollectionForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "collectionform.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// createCollection handler creates an empty collection owned by the current
+// user
+func (app *application) createCollection(w http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validateCollection(form)
+	if !form.Valid() {
+		app.render(w, r, "collectionform.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	id, err := app.collections.Insert(app.authenticatedUserID(r), form.Get("name"), collectionVisibility(form))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Collection created.")
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/%d", id), http.StatusSeeOther)
+}
+
+// editCollectionForm handler displays a form for renaming a collection owned
+// by the current user or changing its visibility
+func (app *application) editCollectionForm(w http.ResponseWriter, r *http.Request) {
+
+	c, ok := app.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+
+	app.render(w, r, "collectionform.page.tmpl", &templateData{
+		Collection: c,
+		Form: forms.New(url.Values{
+			"name":       []string{c.Name},
+			"visibility": []string{c.Visibility},
+		}),
+	})
+}
+
+// editCollection handler saves the changes to a collection owned by the
+// current user
+func (app *application) editCollection(w http.ResponseWriter, r *http.Request) {
+
+	c, ok := app.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validateCollection(form)
+	if !form.Valid() {
+		app.render(w, r, "collectionform.page.tmpl", &templateData{
+			Collection: c,
+			Form:       form,
+		})
+		return
+	}
+
+	err = app.collections.Update(c.ID, app.authenticatedUserID(r), form.Get("name"), collectionVisibility(form))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Collection saved.")
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/%d", c.ID), http.StatusSeeOther)
+}
+
+// deleteCollection handler removes a collection owned by the current user,
+// leaving the snippets in it alone
+func (app *application) deleteCollection(w http.ResponseWriter, r *http.Request) {
+
+	c, ok := app.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.collections.Delete(c.ID, app.authenticatedUserID(r))
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Collection deleted.")
+
+	http.Redirect(w, r, "/user/collections", http.StatusSeeOther)
+}
+
+// userStarred handler lists the snippets the current user has starred
+func (app *application) userStarred(w http.ResponseWriter, r *http.Request) {
+
+	page, err := pageRequest(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.snippets.Starred(app.authenticatedUserID(r), page)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, p := pageLinks(r, page, s)
+	app.render(w, r, "starred.page.tmpl", &templateData{
+		Pagination: p,
+		Snippets:   s,
+	})
+}
+
+// userTrash handler lists the current user's trashed snippets
+func (app *application) userTrash(w http.ResponseWriter, r *http.Request) {
+
+	s, err := app.snippets.Trash(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "trash.page.tmpl", &templateData{
+		Snippets: s,
+	})
+}
+
+// userSnippets handler lists the snippets owned by the current user
+func (app *application) userSnippets(w http.ResponseWriter, r *http.Request) {
+
+	page, err := pageRequest(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.snippets.ByUser(app.authenticatedUserID(r), page)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, p := pageLinks(r, page, s)
+	app.render(w, r, "dashboard.page.tmpl", &templateData{
+		Pagination: p,
+		Snippets:   s,
 	})
 }
 
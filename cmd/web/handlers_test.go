@@ -1,10 +1,20 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"ptodd.org/snippetbox/pkg/models"
+	"ptodd.org/snippetbox/pkg/models/mock"
 )
 
 func TestPing(t *testing.T) {
@@ -40,6 +50,7 @@ func TestShowSnippet(t *testing.T) {
 		wantBody []byte
 	}{
 		{"Valid ID", "/snippet/1", http.StatusOK, []byte("An old silent pond...")},
+		{"Identical content", "/snippet/1", http.StatusOK, []byte("2 other snippets have identical content")},
 		{"Non-existent ID", "/snippet/2", http.StatusNotFound, nil},
 		{"Negative ID", "/snippet/-1", http.StatusNotFound, nil},
 		{"Decimal ID", "/snippet/1.23", http.StatusNotFound, nil},
@@ -111,3 +122,1539 @@ func TestSignupUser(t *testing.T) {
 		})
 	}
 }
+
+func TestUserSnippets(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// An anonymous visitor should be sent to the login page
+	code, header, _ := ts.get(t, "/user/snippets")
+	if code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	if loc := header.Get("Location"); loc != "/user/login" {
+		t.Errorf("want redirect to %q; got %q", "/user/login", loc)
+	}
+
+	// Once logged in the user's own snippets should be listed
+	ts.login(t)
+	code, _, body := ts.get(t, "/user/snippets")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("An old silent pond")) {
+		t.Errorf("want body to contain %q", "An old silent pond")
+	}
+}
+
+func TestEditSnippet(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Log in as the owner of the mock snippet and fetch the pre-filled form
+	ts.login(t)
+	code, _, body := ts.get(t, "/snippet/1/edit")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("An old silent pond...")) {
+		t.Errorf("want body to contain %q", "An old silent pond...")
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		title    string
+		content  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Valid submission", "/snippet/1/edit", "Haiku", "An old silent pond...", http.StatusSeeOther, nil},
+		{"Empty title", "/snippet/1/edit", "", "An old silent pond...", http.StatusOK, []byte("This field cannot be blank")},
+		{"Non-existent ID", "/snippet/2/edit", "Haiku", "An old silent pond...", http.StatusNotFound, nil},
+		{"Too large", "/snippet/1/edit", "Haiku", strings.Repeat("a", int(cfg.maxContentSize)+1), http.StatusOK, []byte("This field is too large (maximum is 2 MB)")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// multiFile is the mock snippet model with a second file in the first
+// snippet
+type multiFile struct {
+	mock.SnippetModel
+}
+
+func (m *multiFile) Files(id int) ([]*models.File, error) {
+	files, err := m.SnippetModel.Files(id)
+	if err != nil || id != 1 {
+		return files, err
+	}
+	return append(files, &models.File{Name: "frog.txt", Language: "text", Content: "A frog jumps in"}), nil
+}
+
+func TestEditMultiFileSnippet(t *testing.T) {
+
+	app := newTestApplication(t)
+	app.snippets = &multiFile{}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	_, _, body := ts.get(t, "/snippet/1")
+	if !bytes.Contains(body, []byte("/snippet/1/edit")) {
+		t.Errorf("want an edit link for a snippet with several files")
+	}
+
+	// The form is pre-filled with every file
+	code, _, body := ts.get(t, "/snippet/1/edit")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, want := range []string{"An old silent pond...", "frog.txt", "A frog jumps in"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		names    []string
+		action   string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Valid submission", []string{"pond.txt", "frog.txt"}, "", http.StatusSeeOther, nil},
+		{"Add file", []string{"pond.txt", "frog.txt"}, "add-file", http.StatusOK, []byte("Remove file")},
+		{"Duplicate names", []string{"frog.txt", "frog.txt"}, "", http.StatusOK, []byte("This field must not repeat an earlier entry")},
+		{"Missing name", []string{"pond.txt", ""}, "", http.StatusOK, []byte("This field cannot be blank")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Haiku")
+			for i, name := range tt.names {
+				form.Add("filename", name)
+				form.Add("language", "text")
+				form.Add("content", fmt.Sprintf("File %d", i))
+			}
+			form.Add("action", tt.action)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/snippet/1/edit", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetHistory(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"History", "/snippet/1/history", http.StatusOK, []byte("An old pond")},
+		{"History of non-existent ID", "/snippet/2/history", http.StatusNotFound, nil},
+		{"Latest changes", "/snippet/1/diff", http.StatusOK, []byte("+An old silent pond...")},
+		{"Explicit revisions", "/snippet/1/diff?from=2&to=1", http.StatusOK, []byte("-An old silent pond...")},
+		{"Non-existent revision", "/snippet/1/diff?from=1&to=3", http.StatusNotFound, nil},
+		{"Malformed revision", "/snippet/1/diff?from=foo", http.StatusBadRequest, nil},
+		{"Non-existent ID", "/snippet/2/diff", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+// fileRevisions is the mock snippet model with a second revision that
+// renames its first file and adds another
+type fileRevisions struct {
+	mock.SnippetModel
+}
+
+func (m *fileRevisions) Revision(id, number int) (*models.Revision, error) {
+	if id != 1 || number < 1 || number > 2 {
+		return nil, models.ErrNoRecord
+	}
+	r := &models.Revision{SnippetID: id, Number: number, UserID: 1, Title: "Pond", Created: time.Now()}
+	r.Files = []*models.File{{Name: "pond.txt", Language: "text", Content: "An old pond"}, {Name: "moon.txt", Language: "text", Content: "Harvest moon"}}
+	if number == 2 {
+		r.Files = []*models.File{{Name: "pond.md", Language: "text", Content: "An old pond"}, {Name: "moon.txt", Language: "text", Content: "Harvest moon"}, {Name: "frog.txt", Language: "text", Content: "A frog jumps in"}}
+	}
+	return r, nil
+}
+
+func TestSnippetDiffFiles(t *testing.T) {
+
+	app := newTestApplication(t)
+	app.snippets = &fileRevisions{}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/snippet/1/diff")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, want := range []string{"--- /dev/null", "+++ r2/frog.txt", "+A frog jumps in", "--- r1/pond.txt", "+++ r2/pond.md", "-An old pond"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	if bytes.Contains(body, []byte("moon.txt")) {
+		t.Errorf("want no diff of the unchanged file")
+	}
+}
+
+// markupRevisions is the mock snippet model with revisions whose title and
+// content are markup
+type markupRevisions struct {
+	mock.SnippetModel
+}
+
+func (m *markupRevisions) Revisions(id int) ([]*models.Revision, error) {
+	r1, _ := m.Revision(id, 1)
+	r2, _ := m.Revision(id, 2)
+	return []*models.Revision{r2, r1}, nil
+}
+
+func (m *markupRevisions) Revision(id, number int) (*models.Revision, error) {
+	if id != 1 || number < 1 || number > 2 {
+		return nil, models.ErrNoRecord
+	}
+	markup := fmt.Sprintf("<script>alert(%d)</script>", number)
+	files := []*models.File{{Name: markup, Language: "text", Content: markup}}
+	return &models.Revision{SnippetID: id, Number: number, UserID: 1, Title: markup, Files: files, Created: time.Now()}, nil
+}
+
+func TestSnippetHistoryEscaping(t *testing.T) {
+
+	app := newTestApplication(t)
+	app.snippets = &markupRevisions{}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for _, urlPath := range []string{"/snippet/1/history", "/snippet/1/diff"} {
+		t.Run(urlPath, func(t *testing.T) {
+			code, _, body := ts.get(t, urlPath)
+			if code != http.StatusOK {
+				t.Fatalf("want %d; got %d", http.StatusOK, code)
+			}
+			if bytes.Contains(body, []byte("<script>alert")) {
+				t.Errorf("want no unescaped markup in body")
+			}
+			if !bytes.Contains(body, []byte("&lt;script&gt;alert(2)")) {
+				t.Errorf("want body to contain %q", "&lt;script&gt;alert(2)")
+			}
+		})
+	}
+}
+
+func TestTrash(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The owner's view of a snippet offers a CSRF protected delete button
+	ts.login(t)
+	_, _, body := ts.get(t, "/snippet/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{"Delete", "/snippet/1/delete", http.StatusSeeOther, "/user/snippets"},
+		{"Restore", "/snippet/1/restore", http.StatusSeeOther, "/user/trash"},
+		{"Purge", "/snippet/1/purge", http.StatusSeeOther, "/user/trash"},
+		{"Delete non-existent ID", "/snippet/2/delete", http.StatusNotFound, ""},
+		{"Restore non-existent ID", "/snippet/2/restore", http.StatusNotFound, ""},
+		{"Purge malformed ID", "/snippet/foo/purge", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want redirect to %q; got %q", tt.wantLocation, loc)
+			}
+		})
+	}
+
+	code, _, body := ts.get(t, "/user/trash")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("The trash is empty.")) {
+		t.Errorf("want body to contain %q", "The trash is empty.")
+	}
+}
+
+func TestSearch(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Private snippets are only found by their owner searching their own
+	code, _, body := ts.get(t, "/search?q=splash&owner=1")
+	if code != http.StatusOK || bytes.Contains(body, []byte("<mark>Splash</mark>! Silence again")) {
+		t.Errorf("want a private snippet hidden from anonymous searches")
+	}
+	ts.login(t)
+	_, _, body = ts.get(t, "/search?q=splash&owner=1")
+	if !bytes.Contains(body, []byte("<mark>Splash</mark>! Silence again")) {
+		t.Errorf("want the owner's private snippet found by their own search")
+	}
+	_, _, body = ts.get(t, "/search?q=splash")
+	if bytes.Contains(body, []byte("<mark>Splash</mark>! Silence again")) {
+		t.Errorf("want a private snippet hidden from searches of everyone's snippets")
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Form only", "/search", http.StatusOK, []byte("<input type='text' name='q' value=''")},
+		{"Match", "/search?q=pond", http.StatusOK, []byte("An old silent <mark>pond</mark>")},
+		{"Match with filters", "/search?q=pond&owner=1&from=2020-01-01&to=2099-12-31", http.StatusOK, []byte("<mark>pond</mark>")},
+		{"No match", "/search?q=frog", http.StatusOK, []byte("No snippets matched your search.")},
+		{"Invalid date", "/search?q=pond&from=yesterday", http.StatusOK, []byte("This field is not a valid date")},
+		{"Invalid owner", "/search?q=pond&owner=-1", http.StatusOK, []byte("This field must be a positive whole number")},
+		{"Previous page", "/search?q=pond&page=2", http.StatusOK, []byte("/search?page=1&amp;q=pond")},
+		{"Escapes query", "/search?q=%3Cscript%3E", http.StatusOK, []byte("value='&lt;script&gt;'")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSearchEncrypted(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	notice := []byte("Only titles are searched, as snippet content is stored encrypted.")
+	_, _, body := ts.get(t, "/search?q=pond")
+	if bytes.Contains(body, notice) {
+		t.Errorf("want no notice without encryption")
+	}
+
+	// With a keyring configured the form says only titles are searched,
+	// whether or not there are any results
+	defer func(path string) { cfg.keyring = path }(cfg.keyring)
+	cfg.keyring = "./tls/keyring"
+	for _, urlPath := range []string{"/search", "/search?q=pond", "/search?q=frog"} {
+		_, _, body = ts.get(t, urlPath)
+		if !bytes.Contains(body, notice) {
+			t.Errorf("%s: want body to contain %q", urlPath, notice)
+		}
+	}
+}
+
+func TestHome(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"First page", "/", http.StatusOK, []byte("An old silent pond")},
+		{"Past the end", "/?before=20201217100000-5", http.StatusOK, []byte("<a class='prev' href='/'>")},
+		{"Malformed cursor", "/?before=yesterday", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestTagSnippets(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Tag chips on snippet", "/snippet/1", http.StatusOK, []byte("<a class='tag' href='/tag/haiku'>haiku</a>")},
+		{"Tagged snippets", "/tag/haiku", http.StatusOK, []byte("An old silent pond")},
+		{"Tag names are case-insensitive", "/tag/HAIKU", http.StatusOK, []byte("Snippets tagged <span class='tag'>haiku</span>")},
+		{"Unused tag", "/tag/limerick", http.StatusOK, []byte("There are no snippets with this tag.")},
+		{"Invalid tag", "/tag/-bad", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+// manyTagged stubs a tag with more snippets than fit on a page
+type manyTagged struct {
+	mock.SnippetModel
+}
+
+func (m *manyTagged) ByTag(tag string, page models.Page) ([]*models.Snippet, error) {
+	s := make([]*models.Snippet, page.Limit)
+	for i := range s {
+		s[i] = &models.Snippet{ID: 100 - i, Title: "An old silent pond", Created: time.Now()}
+	}
+	return s, nil
+}
+
+func TestTagSnippetsPaging(t *testing.T) {
+
+	app := newTestApplication(t)
+	app.snippets = &manyTagged{}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Follow the link to the next page a few times, which should keep the tag
+	// in the path alone and hold only the latest cursor
+	urlPath := "/tag/haiku"
+	for i := 0; i < 3; i++ {
+		code, _, body := ts.get(t, urlPath)
+		if code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+		link := bytes.SplitN(body, []byte("class='next' href='"), 2)
+		if len(link) != 2 {
+			t.Fatalf("want a link to the next page")
+		}
+		urlPath = html.UnescapeString(string(bytes.SplitN(link[1], []byte("'"), 2)[0]))
+
+		u, err := url.Parse(urlPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		if u.Path != "/tag/haiku" || len(q) != 1 || len(q["before"]) != 1 {
+			t.Errorf("want a link to the tag with a single cursor; got %q", urlPath)
+		}
+	}
+}
+
+func TestCreateSnippet(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	_, _, body := ts.get(t, "/snippet/create")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name       string
+		title      string
+		content    string
+		language   string
+		visibility string
+		expires    string
+		tags       string
+		wantCode   int
+		wantBody   []byte
+	}{
+		{"Valid submission", "Haiku", "An old silent pond...", "go", "unlisted", "1w", "haiku, poetry", http.StatusSeeOther, nil},
+		{"No tags, language or visibility", "Haiku", "An old silent pond...", "", "", "1w", "", http.StatusSeeOther, nil},
+		{"Empty title", "", "An old silent pond...", "text", "public", "1w", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Never expires", "Haiku", "An old silent pond...", "text", "public", "never", "", http.StatusSeeOther, nil},
+		{"Invalid expiry", "Haiku", "An old silent pond...", "text", "public", "2", "", http.StatusOK, []byte("This field is invalid")},
+		{"Invalid language", "Haiku", "An old silent pond...", "cobol", "public", "1w", "", http.StatusOK, []byte("This field is invalid")},
+		{"Invalid visibility", "Haiku", "An old silent pond...", "text", "secret", "1w", "", http.StatusOK, []byte("This field is invalid")},
+		{"Invalid tag", "Haiku", "An old silent pond...", "text", "public", "1w", "haiku, <b>", http.StatusOK, []byte("This field contains an invalid entry")},
+		{"Too many tags", "Haiku", "An old silent pond...", "text", "public", "1w", "a,b,c,d,e,f,g,h,i,j,k", http.StatusOK, []byte("This field has too many entries (maximum is 10)")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("language", tt.language)
+			form.Add("visibility", tt.visibility)
+			form.Add("expires", tt.expires)
+			form.Add("tags", tt.tags)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetVisibility(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock unlisted (#3) and private (#4) snippets belong to alice
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Public by ID", "/snippet/1", http.StatusOK, []byte("An old silent pond...")},
+		{"Public by slug", "/s/bWFW3PN7T8nE0D5kb2Vx1g", http.StatusOK, []byte("An old silent pond...")},
+		{"Unlisted by ID", "/snippet/3", http.StatusNotFound, nil},
+		{"Unlisted history by ID", "/snippet/3/history", http.StatusNotFound, nil},
+		{"Unlisted by slug", "/s/K3xhT0mVv1a9pQwYc7ZrEw", http.StatusOK, []byte("A frog jumps into the pond...")},
+		{"Private by ID", "/snippet/4", http.StatusNotFound, nil},
+		{"Private by slug", "/s/Q8bN2sLd5JfX0uHy4GtR6A", http.StatusNotFound, nil},
+		{"Non-existent slug", "/s/AAAAAAAAAAAAAAAAAAAAAA", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run("Anonymous "+tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	// The owner can see everything
+	ts.login(t)
+	for _, urlPath := range []string{"/snippet/3", "/snippet/4", "/s/Q8bN2sLd5JfX0uHy4GtR6A"} {
+		t.Run("Owner "+urlPath, func(t *testing.T) {
+			code, _, _ := ts.get(t, urlPath)
+			if code != http.StatusOK {
+				t.Errorf("want %d; got %d", http.StatusOK, code)
+			}
+		})
+	}
+
+	// The share link is offered for unlisted snippets
+	_, _, body := ts.get(t, "/snippet/3")
+	want := []byte("<a href='/s/K3xhT0mVv1a9pQwYc7ZrEw'>")
+	if !bytes.Contains(body, want) {
+		t.Errorf("want body to contain %q", want)
+	}
+}
+
+func TestBurnAfterReading(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock snippet #5 is unlisted and may be viewed once
+	code, header, body := ts.get(t, "/s/Zx4pW9cTq2Lm7Nb1Vd8Hs0")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, want := range []string{"correct horse battery staple", "This snippet has now been destroyed"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+	if got := header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("want Cache-Control %q; got %q", "no-store", got)
+	}
+
+	// Pages which would show the content without counting a view are hidden
+	code, _, _ = ts.get(t, "/snippet/5/history")
+	if code != http.StatusNotFound {
+		t.Errorf("want %d; got %d", http.StatusNotFound, code)
+	}
+
+	// The owner's views are not counted
+	ts.login(t)
+	_, header, body = ts.get(t, "/snippet/5")
+	want := []byte("Viewed 0 of 1 times")
+	if !bytes.Contains(body, want) {
+		t.Errorf("want body to contain %q", want)
+	}
+	if header.Get("Cache-Control") == "no-store" {
+		t.Errorf("want owner's view to be cacheable")
+	}
+
+	// View limits are validated when creating a snippet
+	_, _, body = ts.get(t, "/snippet/create")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		maxViews string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Burn after reading", "1", http.StatusSeeOther, nil},
+		{"Zero views", "0", http.StatusOK, []byte("This field must be a positive whole number")},
+		{"Too many views", "1001", http.StatusOK, []byte("This field must be no more than 1000")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Password")
+			form.Add("content", "hunter2")
+			form.Add("expires", "1d")
+			form.Add("max_views", tt.maxViews)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestExpiredSnippet(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock tombstone records public snippet #6 expiring
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Expired by ID", "/snippet/6", http.StatusGone, []byte("expired on 01 Mar 2020 at 09:30")},
+		{"Expired by slug", "/s/Pq7Rt2Vw9Xy4Za1Bc6De3F", http.StatusGone, []byte("Snippet #6 has expired")},
+		{"History of expired", "/snippet/6/history", http.StatusGone, nil},
+		{"Never existed", "/snippet/2", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRawSnippet(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantCode        int
+		wantDisposition string
+		wantBody        []byte
+	}{
+		{"Raw", "/snippet/1/raw", http.StatusOK, `inline; filename=an-old-silent-pond.txt`, []byte("An old silent pond...")},
+		{"Download", "/snippet/1/download", http.StatusOK, `attachment; filename=an-old-silent-pond.txt`, []byte("An old silent pond...")},
+		{"Unlisted by ID", "/snippet/3/raw", http.StatusNotFound, "", nil},
+		{"Unlisted by slug", "/s/K3xhT0mVv1a9pQwYc7ZrEw/download", http.StatusOK, `attachment; filename=a-frog-jumps-in.txt`, []byte("A frog jumps into the pond...")},
+		{"Private by slug", "/s/Q8bN2sLd5JfX0uHy4GtR6A/raw", http.StatusNotFound, "", nil},
+		{"Expired", "/snippet/6/raw", http.StatusGone, "", nil},
+		{"Non-existent", "/snippet/2/download", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			if got := header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Errorf("want Content-Type text/plain; got %q", got)
+			}
+			if got := header.Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("want X-Content-Type-Options nosniff; got %q", got)
+			}
+			if got := header.Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Errorf("want Content-Disposition %q; got %q", tt.wantDisposition, got)
+			}
+			if !bytes.HasPrefix(body, tt.wantBody) {
+				t.Errorf("want body to start with %q", tt.wantBody)
+			}
+		})
+	}
+
+	// Fetching the content counts as a view of a view-limited snippet
+	code, header, body := ts.get(t, "/s/Zx4pW9cTq2Lm7Nb1Vd8Hs0/raw")
+	if code != http.StatusOK || string(body) != "correct horse battery staple" {
+		t.Errorf("want %d with the content; got %d %q", http.StatusOK, code, body)
+	}
+	if got := header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("want Cache-Control no-store; got %q", got)
+	}
+}
+
+func TestFilename(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		snippet *models.Snippet
+		want    string
+	}{
+		{"Title", &models.Snippet{ID: 1, Title: "Hello, World!", Language: "go"}, "hello-world.go"},
+		{"Unsafe title", &models.Snippet{ID: 1, Title: `../"etc"/passwd`, Language: "shell"}, "etc-passwd.sh"},
+		{"No usable title", &models.Snippet{ID: 7, Title: "日本語", Language: "markdown"}, "snippet-7.md"},
+		{"Unknown language", &models.Snippet{ID: 1, Title: "Notes", Language: "cobol"}, "notes.txt"},
+		{"Named file", &models.Snippet{ID: 1, Title: "Notes", Filename: "main.go", Language: "go"}, "main.go"},
+		{"Long title", &models.Snippet{ID: 1, Title: strings.Repeat("abcd ", 20), Language: "text"}, strings.TrimSuffix(strings.Repeat("abcd-", 10), "-") + ".txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filename(tt.snippet); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestForkSnippet(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock snippet #7 is a public fork of #1 owned by another user
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantBody []byte
+	}{
+		{"Parent lists forks", "/snippet/1", []byte("1 fork:</strong>")},
+		{"Parent lists fork", "/snippet/1", []byte("<a href='/snippet/7'>#7 An old silent pond, revisited</a>")},
+		{"Fork links parent", "/snippet/7", []byte("Forked from <a href='/snippet/1'>#1 An old silent pond</a>")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != http.StatusOK {
+				t.Errorf("want %d; got %d", http.StatusOK, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	// Only authenticated users are offered the fork action
+	_, _, body := ts.get(t, "/snippet/1")
+	fork := []byte("/snippet/create?fork=bWFW3PN7T8nE0D5kb2Vx1g")
+	if bytes.Contains(body, fork) {
+		t.Errorf("want body not to contain %q", fork)
+	}
+	ts.login(t)
+	_, _, body = ts.get(t, "/snippet/1")
+	if !bytes.Contains(body, fork) {
+		t.Errorf("want body to contain %q", fork)
+	}
+
+	// The create form is pre-filled with a copy of the source snippet
+	prefills := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Public", "/snippet/create?fork=bWFW3PN7T8nE0D5kb2Vx1g", http.StatusOK, []byte("<input type='hidden' name='fork' value='bWFW3PN7T8nE0D5kb2Vx1g'>")},
+		{"Copied content", "/snippet/create?fork=bWFW3PN7T8nE0D5kb2Vx1g", http.StatusOK, []byte("<textarea name='content'>An old silent pond...</textarea>")},
+		{"Own private", "/snippet/create?fork=Q8bN2sLd5JfX0uHy4GtR6A", http.StatusOK, []byte("Splash! Silence again.")},
+		{"Non-existent", "/snippet/create?fork=AAAAAAAAAAAAAAAAAAAAAA", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range prefills {
+		t.Run("Form "+tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	// The fork is created from the submitted copy
+	_, _, body = ts.get(t, "/snippet/create?fork=bWFW3PN7T8nE0D5kb2Vx1g")
+	form := url.Values{}
+	form.Add("title", "An old silent pond")
+	form.Add("content", "An old silent pond...")
+	form.Add("expires", "1w")
+	form.Add("fork", "bWFW3PN7T8nE0D5kb2Vx1g")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/snippet/create", form)
+	if code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+}
+
+func TestMultiFileSnippet(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock snippet #7 has the files pond.txt and frog.go
+	_, _, body := ts.get(t, "/snippet/7")
+	for _, want := range []string{"2 files #7", "<strong>pond.txt</strong>", "<strong>frog.go</strong>", "<a href='/snippet/7/zip'>Download ZIP</a>"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+
+	// The zip archive bundles every file
+	code, header, body := ts.get(t, "/snippet/7/zip")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if got := header.Get("Content-Disposition"); got != "attachment; filename=an-old-silent-pond-revisited.zip" {
+		t.Errorf("want Content-Disposition for the zip; got %q", got)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"pond.txt": "An old silent pond... a frog jumps in", "frog.go": "package frog"}
+	if len(zr.File) != len(want) {
+		t.Errorf("want %d files; got %d", len(want), len(zr.File))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want[f.Name] {
+			t.Errorf("want %s to contain %q; got %q", f.Name, want[f.Name], content)
+		}
+	}
+
+	// The zip follows the same rules as the snippet page
+	for urlPath, wantCode := range map[string]int{
+		"/snippet/3/zip":                http.StatusNotFound,
+		"/s/K3xhT0mVv1a9pQwYc7ZrEw/zip": http.StatusOK,
+		"/snippet/6/zip":                http.StatusGone,
+	} {
+		if code, _, _ := ts.get(t, urlPath); code != wantCode {
+			t.Errorf("%s: want %d; got %d", urlPath, wantCode, code)
+		}
+	}
+}
+
+func TestCreateMultiFileSnippet(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	_, _, body := ts.get(t, "/snippet/create")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name      string
+		filenames []string
+		contents  []string
+		extra     url.Values
+		wantCode  int
+		wantBody  []byte
+	}{
+		{"Add a file", []string{"a.go"}, []string{"package a"}, url.Values{"action": {"add-file"}}, http.StatusOK, []byte("<textarea name='content'></textarea>")},
+		{"Remove a file", []string{"a.go", "b.go"}, []string{"package a", "package b"}, url.Values{"remove": {"0"}}, http.StatusOK, []byte("value='b.go'")},
+		{"Valid files", []string{"a.go", "b.go"}, []string{"package a", "package b"}, nil, http.StatusSeeOther, nil},
+		{"Unnamed file", []string{"a.go", ""}, []string{"package a", "package b"}, nil, http.StatusOK, []byte("This field cannot be blank")},
+		{"Repeated name", []string{"a.go", "a.go"}, []string{"package a", "package b"}, nil, http.StatusOK, []byte("This field must not repeat an earlier entry")},
+		{"Path in name", []string{"../a.go"}, []string{"package a"}, nil, http.StatusOK, []byte("This field is invalid")},
+		{"Empty file", []string{"a.go", "b.go"}, []string{"package a", " "}, nil, http.StatusOK, []byte("This field cannot be blank")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Packages")
+			form.Add("expires", "1w")
+			form.Add("csrf_token", csrfToken)
+			for i := range tt.contents {
+				form.Add("filename", tt.filenames[i])
+				form.Add("language", "go")
+				form.Add("content", tt.contents[i])
+			}
+			for k, v := range tt.extra {
+				form[k] = v
+			}
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAttachments(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The mock snippets #1 (public), #3 (unlisted) and #4 (private) each
+	// have a log file attached, as attachments 1, 2 and 4.  Attachments are
+	// served to logged in users who may see their snippet.
+	tests := []struct {
+		name     string
+		urlPath  string
+		login    bool
+		wantCode int
+		wantBody []byte
+	}{
+		{"Public without login", "/snippet/1/attachment/1", false, http.StatusSeeOther, nil},
+		{"Unlisted by slug without login", "/s/K3xhT0mVv1a9pQwYc7ZrEw/attachment/2", false, http.StatusSeeOther, nil},
+		{"Public", "/snippet/1/attachment/1", true, http.StatusOK, []byte("the pond was silent")},
+		{"Wrong snippet", "/snippet/1/attachment/2", true, http.StatusNotFound, nil},
+		{"Non-existent", "/snippet/1/attachment/9", true, http.StatusNotFound, nil},
+		{"Malformed", "/snippet/1/attachment/x", true, http.StatusNotFound, nil},
+		{"Unlisted by slug", "/s/K3xhT0mVv1a9pQwYc7ZrEw/attachment/2", true, http.StatusOK, []byte("a frog jumped in")},
+		{"Expired", "/snippet/6/attachment/1", true, http.StatusGone, nil},
+		{"Own private by ID", "/snippet/4/attachment/4", true, http.StatusOK, []byte("silence again")},
+		{"Own private by slug", "/s/Q8bN2sLd5JfX0uHy4GtR6A/attachment/4", true, http.StatusOK, []byte("silence again")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.login {
+				ts.login(t)
+			}
+			code, header, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if code == http.StatusSeeOther && header.Get("Location") != "/user/login" {
+				t.Errorf("want redirect to /user/login; got %q", header.Get("Location"))
+			}
+			if code != http.StatusOK {
+				return
+			}
+			if got := header.Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("want X-Content-Type-Options nosniff; got %q", got)
+			}
+			if got := header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Errorf("want the detected Content-Type; got %q", got)
+			}
+		})
+	}
+
+	// Attachments are listed on the snippet page
+	_, _, body := ts.get(t, "/snippet/1")
+	want := []byte("<a href='/snippet/1/attachment/1'>pond.log</a> (19 bytes)")
+	if !bytes.Contains(body, want) {
+		t.Errorf("want body to contain %q", want)
+	}
+
+	// Uploads are checked before the snippet is created.  Alice has used
+	// 95 MB of the default 100 MB quota.
+	_, _, body = ts.get(t, "/snippet/create")
+	csrfToken := extractCSRFToken(t, body)
+
+	uploads := []struct {
+		name     string
+		files    map[string]string
+		maxViews string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Log", map[string]string{"build.log": "ok\nok\nFAIL\n"}, "", http.StatusSeeOther, nil},
+		{"Screenshot", map[string]string{"shot.png": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"}, "", http.StatusSeeOther, nil},
+		{"Disguised page", map[string]string{"shot.png": "<html><script>alert(1)</script>"}, "", http.StatusOK, []byte("shot.png is not a permitted type of file")},
+		{"Too large", map[string]string{"big.log": strings.Repeat("x", 10<<20+1)}, "", http.StatusOK, []byte("big.log is too large (maximum is 10 MB)")},
+		{"Over quota", map[string]string{"a.log": strings.Repeat("x", 3<<20), "b.log": strings.Repeat("x", 3<<20)}, "", http.StatusOK, []byte("These files would take you over your 100 MB of storage (95 MB used)")},
+		{"View-limited", map[string]string{"build.log": "ok"}, "1", http.StatusOK, []byte("Files cannot be attached to view-limited snippets")},
+	}
+
+	for _, tt := range uploads {
+		t.Run("Upload "+tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Build failure")
+			form.Add("content", "See the log")
+			form.Add("expires", "1w")
+			form.Add("max_views", tt.maxViews)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postMultipart(t, "/snippet/create", form, map[string]map[string]string{"attachments": tt.files})
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// othersPrivate stubs the private snippet as belonging to another user
+type othersPrivate struct {
+	mock.SnippetModel
+}
+
+func (m *othersPrivate) Get(id int) (*models.Snippet, error) {
+	return m.others(m.SnippetModel.Get(id))
+}
+
+func (m *othersPrivate) BySlug(slug string) (*models.Snippet, error) {
+	return m.others(m.SnippetModel.BySlug(slug))
+}
+
+func (m *othersPrivate) others(s *models.Snippet, err error) (*models.Snippet, error) {
+	if err != nil || s.Visibility != models.Private {
+		return s, err
+	}
+	other := *s
+	other.UserID = 2
+	return &other, nil
+}
+
+func TestOthersPrivateAttachment(t *testing.T) {
+
+	app := newTestApplication(t)
+	app.snippets = &othersPrivate{}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	for _, urlPath := range []string{"/snippet/4/attachment/4", "/s/Q8bN2sLd5JfX0uHy4GtR6A/attachment/4"} {
+		t.Run(urlPath, func(t *testing.T) {
+			if code, _, _ := ts.get(t, urlPath); code != http.StatusNotFound {
+				t.Errorf("want %d; got %d", http.StatusNotFound, code)
+			}
+		})
+	}
+}
+
+func TestSnippetViews(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Repeat views from the same session are only counted once
+	for i := 0; i < 3; i++ {
+		code, _, body := ts.get(t, "/snippet/1")
+		if code != http.StatusOK {
+			t.Fatalf("want %d; got %d", http.StatusOK, code)
+		}
+		if !bytes.Contains(body, []byte("Viewed once")) {
+			t.Errorf("want body to contain %q", "Viewed once")
+		}
+	}
+	if got := app.views.pending(1); got != 1 {
+		t.Errorf("want 1 pending view; got %d", got)
+	}
+
+	// Unlisted snippets are counted when viewed by slug, but the owner's
+	// views are not
+	ts.get(t, "/s/K3xhT0mVv1a9pQwYc7ZrEw")
+	ts.login(t)
+	ts.get(t, "/s/K3xhT0mVv1a9pQwYc7ZrEw")
+	ts.get(t, "/snippet/1")
+	if got := app.views.pending(3); got != 1 {
+		t.Errorf("want 1 pending view of the unlisted snippet; got %d", got)
+	}
+	if got := app.views.pending(1); got != 1 {
+		t.Errorf("want the owner's view not to be counted; got %d pending", got)
+	}
+
+	// Writing the views empties the counter
+	app.writeViews()
+	if got := app.views.pending(1); got != 0 {
+		t.Errorf("want no pending views once written; got %d", got)
+	}
+}
+
+func TestStars(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Listings show the star counts, but only authenticated users may star
+	_, _, body := ts.get(t, "/")
+	if !bytes.Contains(body, []byte("<td>1</td>")) {
+		t.Errorf("want body to contain the star count")
+	}
+	_, _, body = ts.get(t, "/snippet/1")
+	if !bytes.Contains(body, []byte("★ 1 · ")) {
+		t.Errorf("want body to contain the star count")
+	}
+	if bytes.Contains(body, []byte("/snippet/1/star")) {
+		t.Errorf("want body not to offer starring")
+	}
+
+	// The mock user has starred #1 but not the unlisted #3
+	ts.login(t)
+	_, _, body = ts.get(t, "/snippet/1")
+	if !bytes.Contains(body, []byte("<form action='/snippet/1/unstar' method='POST'>")) {
+		t.Errorf("want body to offer unstarring")
+	}
+	_, _, body = ts.get(t, "/s/K3xhT0mVv1a9pQwYc7ZrEw")
+	if !bytes.Contains(body, []byte("<form action='/s/K3xhT0mVv1a9pQwYc7ZrEw/star' method='POST'>")) {
+		t.Errorf("want body to offer starring")
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{"Star", "/snippet/1/star", http.StatusSeeOther, "/snippet/1"},
+		{"Unstar", "/snippet/1/unstar", http.StatusSeeOther, "/snippet/1"},
+		{"Unlisted by slug", "/s/K3xhT0mVv1a9pQwYc7ZrEw/star", http.StatusSeeOther, "/s/K3xhT0mVv1a9pQwYc7ZrEw"},
+		{"Expired", "/snippet/6/star", http.StatusGone, ""},
+		{"Non-existent", "/snippet/2/star", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if got := header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, got)
+			}
+		})
+	}
+
+	// The starred page lists the user's starred snippets
+	code, _, body := ts.get(t, "/user/starred")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	for _, title := range []string{"An old silent pond", "An old silent pond, revisited"} {
+		if !bytes.Contains(body, []byte(">"+title+"</a>")) {
+			t.Errorf("want body to contain %q", title)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Comments anchored to lines of the current revision are shown beneath
+	// them, and the others after the snippet, to everyone
+	_, _, body := ts.get(t, "/snippet/1")
+	shown := []string{
+		"<tr id='f0L1'>",
+		"<tr class='comments'>",
+		"on <a href='#f0L1'>line 1</a>",
+		"on line 1 of revision 1",
+		"<p>Where is the frog?</p>",
+		"<p>Thank you</p>",
+	}
+	for _, s := range shown {
+		if !bytes.Contains(body, []byte(s)) {
+			t.Errorf("want body to contain %q", s)
+		}
+	}
+	if bytes.Contains(body, []byte("/comment'")) {
+		t.Errorf("want body not to offer commenting")
+	}
+
+	// The snippet's owner may delete any of its comments
+	ts.login(t)
+	_, _, body = ts.get(t, "/snippet/1")
+	if !bytes.Contains(body, []byte("<form action='/snippet/1/comment/4/delete' method='POST'>")) {
+		t.Errorf("want body to offer deleting comments")
+	}
+	_, _, body = ts.get(t, "/snippet/7")
+	if !bytes.Contains(body, []byte("<tr id='f1L1'>")) || !bytes.Contains(body, []byte("The frog needs a func")) {
+		t.Errorf("want body to contain the comment on the second file")
+	}
+	if bytes.Contains(body, []byte("/comment/5/delete")) {
+		t.Errorf("want body not to offer deleting someone else's comment")
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Comment", "/snippet/1/comment", url.Values{"body": {"Hush"}}, http.StatusSeeOther, "/snippet/1#comment-6", nil},
+		{"Line comment", "/snippet/7/comment", url.Values{"body": {"Hush"}, "file": {"1"}, "from": {"1"}}, http.StatusSeeOther, "/snippet/7#comment-6", nil},
+		{"Reply", "/snippet/1/comment", url.Values{"body": {"Hush"}, "parent": {"1"}}, http.StatusSeeOther, "/snippet/1#comment-6", nil},
+		{"Unlisted by slug", "/s/K3xhT0mVv1a9pQwYc7ZrEw/comment", url.Values{"body": {"Hush"}}, http.StatusSeeOther, "/s/K3xhT0mVv1a9pQwYc7ZrEw#comment-6", nil},
+		{"Blank", "/snippet/1/comment", url.Values{"body": {" "}}, http.StatusOK, "", []byte("This field cannot be blank")},
+		{"Blank reply", "/snippet/1/comment", url.Values{"body": {""}, "parent": {"1"}}, http.StatusOK, "", []byte("This field cannot be blank")},
+		{"Past the last line", "/snippet/1/comment", url.Values{"body": {"Hush"}, "from": {"2"}}, http.StatusOK, "", []byte("This field must be no more than 1")},
+		{"No such file", "/snippet/7/comment", url.Values{"body": {"Hush"}, "file": {"2"}, "from": {"1"}}, http.StatusOK, "", []byte("This field is invalid")},
+		{"Reply to a reply", "/snippet/1/comment", url.Values{"body": {"Hush"}, "parent": {"2"}}, http.StatusBadRequest, "", nil},
+		{"Reply elsewhere", "/snippet/7/comment", url.Values{"body": {"Hush"}, "parent": {"1"}}, http.StatusBadRequest, "", nil},
+		{"Non-existent", "/snippet/2/comment", url.Values{"body": {"Hush"}}, http.StatusNotFound, "", nil},
+		{"Delete", "/snippet/1/comment/1/delete", url.Values{}, http.StatusSeeOther, "/snippet/1#comments", nil},
+		{"Delete elsewhere", "/snippet/7/comment/1/delete", url.Values{}, http.StatusNotFound, "", nil},
+		{"Delete someone else's", "/snippet/7/comment/5/delete", url.Values{}, http.StatusForbidden, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Set("csrf_token", csrfToken)
+			code, header, body := ts.postForm(t, tt.urlPath, tt.form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if got := header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, got)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCollections(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Collections are reachable by ID only for their owner, and by slug for
+	// anyone when shared
+	pages := []struct {
+		name     string
+		urlPath  string
+		login    bool
+		wantCode int
+		wantBody []byte
+	}{
+		{"Shared by slug", "/c/ELQGnwjXNZPzZ7EFpU0MOQ", false, http.StatusOK, []byte("<a href='/s/K3xhT0mVv1a9pQwYc7ZrEw'>A frog jumps in</a>")},
+		{"Shared by ID", "/collection/2", false, http.StatusNotFound, nil},
+		{"Private by slug", "/c/gjXqmccy3FN3z-0_EIkKrA", false, http.StatusNotFound, nil},
+		{"Own by ID", "/collection/1", true, http.StatusOK, []byte("<a href='/snippet/1'>An old silent pond</a>")},
+		{"Own private by slug", "/c/gjXqmccy3FN3z-0_EIkKrA", true, http.StatusOK, []byte("Private: only you can see this collection")},
+		{"Someone else's", "/collection/3", true, http.StatusNotFound, nil},
+		{"Non-existent", "/collection/9", true, http.StatusNotFound, nil},
+		{"Listing", "/user/collections", true, http.StatusOK, []byte("<a href='/collection/2'>Frogs</a>")},
+		{"On the snippet page", "/snippet/1", true, http.StatusOK, []byte("<option value='2'>Frogs</option>")},
+	}
+
+	for _, tt := range pages {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.login {
+				ts.login(t)
+			}
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	_, _, body := ts.get(t, "/collection/create")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		form         url.Values
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Create", "/collection/create", url.Values{"name": {"k8s manifests"}}, http.StatusSeeOther, "/collection/4", nil},
+		{"Create blank", "/collection/create", url.Values{"name": {""}}, http.StatusOK, "", []byte("This field cannot be blank")},
+		{"Create public", "/collection/create", url.Values{"name": {"Everything"}, "visibility": {"public"}}, http.StatusOK, "", []byte("This field is invalid")},
+		{"Rename", "/collection/1/edit", url.Values{"name": {"Poems"}, "visibility": {"unlisted"}}, http.StatusSeeOther, "/collection/1", nil},
+		{"Rename someone else's", "/collection/3/edit", url.Values{"name": {"Mine"}}, http.StatusNotFound, "", nil},
+		{"Add", "/snippet/1/collect", url.Values{"collection": {"2"}}, http.StatusSeeOther, "/snippet/1", nil},
+		{"Add to someone else's", "/snippet/1/collect", url.Values{"collection": {"3"}}, http.StatusNotFound, "", nil},
+		{"Add to nothing", "/snippet/1/collect", url.Values{"collection": {"frogs"}}, http.StatusBadRequest, "", nil},
+		{"Remove by slug", "/s/K3xhT0mVv1a9pQwYc7ZrEw/uncollect", url.Values{"collection": {"2"}}, http.StatusSeeOther, "/s/K3xhT0mVv1a9pQwYc7ZrEw", nil},
+		{"Delete", "/collection/1/delete", url.Values{}, http.StatusSeeOther, "/user/collections", nil},
+		{"Delete someone else's", "/collection/3/delete", url.Values{}, http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Set("csrf_token", csrfToken)
+			code, header, body := ts.postForm(t, tt.urlPath, tt.form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if got := header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("want Location %q; got %q", tt.wantLocation, got)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+// markupCollection stubs a collection whose name is markup
+type markupCollection struct {
+	mock.CollectionModel
+}
+
+func (m *markupCollection) Get(id int) (*models.Collection, error) {
+	return &models.Collection{ID: id, UserID: 1, Name: "<script>alert(1)</script>", Visibility: models.Private}, nil
+}
+
+func TestCollectFlashEscaping(t *testing.T) {
+
+	app := newTestApplication(t)
+	app.collections = &markupCollection{}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	_, _, body := ts.get(t, "/snippet/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{"collection": {"2"}, "csrf_token": {csrfToken}}
+	if code, _, _ := ts.postForm(t, "/snippet/1/collect", form); code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+
+	// The collection's name is shown in the flash message on the snippet
+	_, _, body = ts.get(t, "/snippet/1")
+	if bytes.Contains(body, []byte("<script>alert")) {
+		t.Errorf("want no unescaped markup in body")
+	}
+	if !bytes.Contains(body, []byte("Snippet added to &lt;script&gt;alert(1)")) {
+		t.Errorf("want body to contain the escaped flash message")
+	}
+}
+
+func TestEmbedSnippet(t *testing.T) {
+
+	// Only the embed pages may be framed, and only by the configured origins
+	defer func(origins originList) { cfg.frameAncestors = origins }(cfg.frameAncestors)
+	cfg.frameAncestors = originList{"https://wiki.example.com"}
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name             string
+		urlPath          string
+		wantCode         int
+		wantFrameOptions string
+		wantPolicy       string
+		wantBody         []byte
+	}{
+		{"Public", "/snippet/1/embed", http.StatusOK, "", "frame-ancestors https://wiki.example.com", []byte("<a href='/snippet/1'>View on Snippetbox</a>")},
+		{"Several files", "/snippet/7/embed", http.StatusOK, "", "frame-ancestors https://wiki.example.com", []byte("<strong>frog.go</strong>")},
+		{"Unlisted by slug", "/s/K3xhT0mVv1a9pQwYc7ZrEw/embed", http.StatusOK, "", "frame-ancestors https://wiki.example.com", []byte("<a href='/s/K3xhT0mVv1a9pQwYc7ZrEw'>View on Snippetbox</a>")},
+		{"Unlisted by ID", "/snippet/3/embed", http.StatusNotFound, "", "frame-ancestors https://wiki.example.com", nil},
+		{"Private", "/s/Q8bN2sLd5JfX0uHy4GtR6A/embed", http.StatusNotFound, "", "frame-ancestors https://wiki.example.com", nil},
+		{"View-limited", "/s/Zx4pW9cTq2Lm7Nb1Vd8Hs0/embed", http.StatusNotFound, "", "frame-ancestors https://wiki.example.com", nil},
+		{"Snippet page", "/snippet/1", http.StatusOK, "deny", "", []byte(`data-snippet="/snippet/1"`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if got := header.Get("X-Frame-Options"); got != tt.wantFrameOptions {
+				t.Errorf("want X-Frame-Options %q; got %q", tt.wantFrameOptions, got)
+			}
+			if got := header.Get("Content-Security-Policy"); got != tt.wantPolicy {
+				t.Errorf("want Content-Security-Policy %q; got %q", tt.wantPolicy, got)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestOEmbed(t *testing.T) {
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		query      string
+		wantCode   int
+		wantWidth  int
+		wantHeight int
+		wantHTML   string
+	}{
+		{"Public", "url=" + url.QueryEscape(ts.URL+"/snippet/1") + "&format=json", http.StatusOK, 800, 135, `src="` + ts.URL + `/snippet/1/embed"`},
+		{"Several files", "url=" + url.QueryEscape(ts.URL+"/snippet/7"), http.StatusOK, 800, 270, `src="` + ts.URL + `/snippet/7/embed"`},
+		{"Limited size", "url=" + url.QueryEscape(ts.URL+"/snippet/1") + "&maxwidth=300&maxheight=100", http.StatusOK, 300, 100, `width="300" height="100"`},
+		{"Unlisted by slug", "url=" + url.QueryEscape(ts.URL+"/s/K3xhT0mVv1a9pQwYc7ZrEw"), http.StatusOK, 800, 135, `src="` + ts.URL + `/s/K3xhT0mVv1a9pQwYc7ZrEw/embed"`},
+		{"Unlisted by ID", "url=" + url.QueryEscape(ts.URL+"/snippet/3"), http.StatusNotFound, 0, 0, ""},
+		{"Private", "url=" + url.QueryEscape(ts.URL+"/s/Q8bN2sLd5JfX0uHy4GtR6A"), http.StatusUnauthorized, 0, 0, ""},
+		{"Private by ID", "url=" + url.QueryEscape(ts.URL+"/snippet/4"), http.StatusNotFound, 0, 0, ""},
+		{"View-limited", "url=" + url.QueryEscape(ts.URL+"/s/Zx4pW9cTq2Lm7Nb1Vd8Hs0"), http.StatusNotFound, 0, 0, ""},
+		{"Expired", "url=" + url.QueryEscape(ts.URL+"/snippet/6"), http.StatusNotFound, 0, 0, ""},
+		{"Not a snippet", "url=" + url.QueryEscape(ts.URL+"/user/snippets"), http.StatusNotFound, 0, 0, ""},
+		{"Another site", "url=" + url.QueryEscape("https://example.com/snippet/1"), http.StatusNotFound, 0, 0, ""},
+		{"No URL", "format=json", http.StatusBadRequest, 0, 0, ""},
+		{"Bad size", "url=" + url.QueryEscape(ts.URL+"/snippet/1") + "&maxwidth=wide", http.StatusBadRequest, 0, 0, ""},
+		{"XML", "url=" + url.QueryEscape(ts.URL+"/snippet/1") + "&format=xml", http.StatusNotImplemented, 0, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, "/oembed?"+tt.query)
+			if code != tt.wantCode {
+				t.Fatalf("want %d; got %d", tt.wantCode, code)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			if got := header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("want Content-Type %q; got %q", "application/json", got)
+			}
+
+			var res oembedResponse
+			if err := json.Unmarshal(body, &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Version != "1.0" || res.Type != "rich" {
+				t.Errorf("want a version 1.0 rich response; got %q %q", res.Version, res.Type)
+			}
+			if res.Width != tt.wantWidth || res.Height != tt.wantHeight {
+				t.Errorf("want %dx%d; got %dx%d", tt.wantWidth, tt.wantHeight, res.Width, res.Height)
+			}
+			if !strings.Contains(res.HTML, tt.wantHTML) {
+				t.Errorf("want html to contain %q; got %q", tt.wantHTML, res.HTML)
+			}
+		})
+	}
+
+	// Snippet pages advertise the endpoint
+	_, _, body := ts.get(t, "/snippet/1")
+	want := []byte("<link rel='alternate' type='application/json+oembed' href='" + ts.URL + "/oembed?url=" + url.QueryEscape(ts.URL+"/snippet/1") + "&amp;format=json'")
+	if !bytes.Contains(body, want) {
+		t.Errorf("want body to contain %q", want)
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/justinas/nosurf"
+	"ptodd.org/snippetbox/pkg/models"
 )
 
 // serverError helper writes an error message and stack trace to the errorLog,
@@ -37,12 +42,14 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 
 	// Populate common data
 	td.CurrentYear = time.Now().Year()
+	td.SiteURL = siteURL(r)
 
 	// Retreive flash message from user session (if one)
 	td.Flash = app.session.PopString(r, "flash")
 
 	// Determine authentication status
 	td.IsAuthenticated = app.isAuthenticated(r)
+	td.AuthenticatedUserID = app.authenticatedUserID(r)
 
 	// Add the CSRF protection token
 	td.CSRFToken = nosurf.Token(r)
@@ -54,6 +61,12 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 // renders the page to a buffer to trap render errors.  If succesful displays
 // the page; otherwise, gracefully fails
 func (app *application) render(w http.ResponseWriter, r *http.Request, name string, td *templateData) {
+	app.renderStatus(w, r, http.StatusOK, name, td)
+}
+
+// renderStatus helper behaves like render but sends the page with the given
+// status code
+func (app *application) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, td *templateData) {
 
 	// Retrieve template set from cache based upon teh page name.  If no entry
 	// exists, error out gracefully
@@ -75,6 +88,7 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, name stri
 	}
 
 	// Write out the page
+	w.WriteHeader(status)
 	buf.WriteTo(w)
 }
 
@@ -84,3 +98,343 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(contextKeyIsAuthenticated).(bool) // cast the {interface} into the expected type
 	return ok && isAuthenticated
 }
+
+// authenticatedUserID returns the ID of the user the current request has been
+// authenticated as, or zero if the request is not authenticated
+func (app *application) authenticatedUserID(r *http.Request) int {
+	if !app.isAuthenticated(r) {
+		return 0
+	}
+	return app.session.GetInt(r, "authenticatedUserID")
+}
+
+// snippet helper retrieves the snippet identified by the ':id' URL parameter.
+// If the ID is malformed, no such snippet exists, or the snippet is not public
+// and belongs to another user a 404 is sent; any other failure results in a
+// 500.  Unlisted snippets are only reachable by ID for their owner so that
+// IDs cannot be enumerated to find them.  The boolean result reports whether
+// the caller should continue handling the request.
+//
+// View-limited snippets are also hidden from everyone but their owner, as
+// only the snippet page and its content count views (c.f. countView).
+func (app *application) snippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return nil, false
+	}
+	if s.MaxViews > 0 && s.UserID != app.authenticatedUserID(r) {
+		app.notFound(w)
+		return nil, false
+	}
+	return s, true
+}
+
+// viewableSnippet helper behaves like snippet but allows view-limited
+// snippets through; the caller must count the view before showing them.  The
+// snippet may also be named by a ':slug' URL parameter, which is how unlisted
+// snippets are shared, so knowing it is enough for anything but a private one.
+func (app *application) viewableSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	if slug := r.URL.Query().Get(":slug"); slug != "" {
+		s, err := app.snippets.BySlug(slug)
+		if err != nil && errors.Is(err, models.ErrNoRecord) {
+			t, err := app.snippets.TombstoneBySlug(slug)
+			app.gone(w, r, t, err, true)
+			return nil, false
+		}
+		if err != nil {
+			app.serverError(w, err)
+			return nil, false
+		}
+		if s.Visibility == models.Private && s.UserID != app.authenticatedUserID(r) {
+			app.notFound(w)
+			return nil, false
+		}
+		return s, true
+	}
+
+	// Extract expected 'id' parameter from query string
+	id, ok := snippetID(r)
+	if !ok {
+		app.notFound(w)
+		return nil, false
+	}
+
+	// Use the model's get method to receive a record based upon its ID then
+	// return the record or a 404
+	s, err := app.snippets.Get(id)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		t, err := app.snippets.Tombstone(id)
+		app.gone(w, r, t, err, false)
+		return nil, false
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+	if s.Visibility != models.Public && s.UserID != app.authenticatedUserID(r) {
+		app.notFound(w)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// countView helper counts a view of a snippet returned by viewableSnippet by
+// anyone other than the owner of a view-limited snippet, which may destroy
+// it.  The counted snippet is returned, or a 404 sent if someone else used up
+// the last view since it was retrieved.
+func (app *application) countView(w http.ResponseWriter, r *http.Request, s *models.Snippet) (*models.Snippet, bool) {
+	if s.MaxViews == 0 || s.UserID == app.authenticatedUserID(r) {
+		return s, true
+	}
+
+	s, err := app.snippets.View(s.ID)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return nil, false
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	// Keep the content out of browser and proxy caches
+	w.Header().Set("Cache-Control", "no-store")
+	return s, true
+}
+
+// files helper retrieves the files of a snippet returned by viewableSnippet,
+// sending a 404 if it has gone since
+func (app *application) files(w http.ResponseWriter, s *models.Snippet) ([]*models.File, bool) {
+	files, err := app.snippets.Files(s.ID)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return nil, false
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+	return files, true
+}
+
+// forkSource helper retrieves the snippet with the given slug for the current
+// user to fork.  Anyone who can see a snippet may fork it, except that
+// view-limited snippets may only be forked by their owner as copying them
+// would not count a view.  If it may not be forked models.ErrNoRecord is
+// returned.
+func (app *application) forkSource(r *http.Request, slug string) (*models.Snippet, error) {
+	s, err := app.snippets.BySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	userID := app.authenticatedUserID(r)
+	if (s.Visibility == models.Private || s.MaxViews > 0) && s.UserID != userID {
+		return nil, models.ErrNoRecord
+	}
+	return s, nil
+}
+
+// gone helper responds to a request for a snippet which could not be found.
+// If the tombstone lookup found the snippet has expired and the user could
+// have seen it through the URL requested, a 410 Gone page saying when is
+// sent; otherwise a 404 so as not to reveal that a hidden snippet existed.
+func (app *application) gone(w http.ResponseWriter, r *http.Request, t *models.Tombstone, err error, viaSlug bool) {
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	visible := err == nil && (t.Visibility == models.Public ||
+		(viaSlug && t.Visibility == models.Unlisted) ||
+		t.UserID == app.authenticatedUserID(r))
+	if !visible {
+		app.notFound(w)
+		return
+	}
+
+	app.renderStatus(w, r, http.StatusGone, "gone.page.tmpl", &templateData{
+		Tombstone: t,
+	})
+}
+
+// snippetID helper extracts the snippet ID from the ':id' URL parameter and
+// reports whether it is well formed
+func snippetID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		return 0, false
+	}
+	return id, true
+}
+
+// ownedSnippet helper behaves like snippet but additionally sends a 403 if the
+// snippet does not belong to the current user
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := app.snippet(w, r)
+	if !ok {
+		return nil, false
+	}
+	if s.UserID != app.authenticatedUserID(r) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return s, true
+}
+
+// collection helper retrieves the collection identified by the ':id' URL
+// parameter or, for shared collections, the ':slug' one.  As with snippets,
+// collections are only reachable by ID for their owner, and private ones not
+// by slug for anyone else either; a 404 is sent in those cases and when there
+// is no such collection.  The boolean result reports whether the caller
+// should continue handling the request.
+func (app *application) collection(w http.ResponseWriter, r *http.Request) (*models.Collection, bool) {
+	var c *models.Collection
+	var err error
+	if slug := r.URL.Query().Get(":slug"); slug != "" {
+		c, err = app.collections.BySlug(slug)
+	} else {
+		id, convErr := strconv.Atoi(r.URL.Query().Get(":id"))
+		if convErr != nil || id < 1 {
+			app.notFound(w)
+			return nil, false
+		}
+		c, err = app.collections.Get(id)
+	}
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return nil, false
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	shared := c.Visibility == models.Unlisted && r.URL.Query().Get(":slug") != ""
+	if !shared && c.UserID != app.authenticatedUserID(r) {
+		app.notFound(w)
+		return nil, false
+	}
+	return c, true
+}
+
+// ownedCollection helper behaves like collection but responds with a 403
+// unless the collection belongs to the current user
+func (app *application) ownedCollection(w http.ResponseWriter, r *http.Request) (*models.Collection, bool) {
+	c, ok := app.collection(w, r)
+	if !ok {
+		return nil, false
+	}
+	if c.UserID != app.authenticatedUserID(r) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return c, true
+}
+
+// intParam helper returns the named query string parameter as an integer, or
+// the given default if the parameter is absent
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// linkQuery helper returns a copy of the query string of the current request
+// from which to link to other pages of it.  The parameters pat adds for the
+// named parts of the path, such as ':name', are left out as the path already
+// holds them.
+func linkQuery(r *http.Request) url.Values {
+	q := url.Values{}
+	for k, v := range r.URL.Query() {
+		if !strings.HasPrefix(k, ":") {
+			q[k] = v
+		}
+	}
+	return q
+}
+
+// pageURL helper returns the URL of the current request with its 'page' query
+// string parameter replaced
+func pageURL(r *http.Request, page int) string {
+	q := linkQuery(r)
+	q.Set("page", strconv.Itoa(page))
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// listPageSize is the number of snippets shown on each page of a listing
+const listPageSize = 10
+
+// pageRequest helper reads the page of a listing being requested from the
+// 'before' or 'after' query string cursors.  One more snippet than is shown is
+// requested so pageLinks can tell whether there is a further page.
+func pageRequest(r *http.Request) (models.Page, error) {
+	page := models.Page{Limit: listPageSize + 1}
+	var err error
+	if v := r.URL.Query().Get("before"); v != "" {
+		page.Before, err = models.ParseCursor(v)
+	} else if v := r.URL.Query().Get("after"); v != "" {
+		page.After, err = models.ParseCursor(v)
+	}
+	return page, err
+}
+
+// pageLinks helper trims the extra snippet fetched for a page request and
+// returns the links to the newer ('Prev') and older ('Next') neighbouring
+// pages of the listing
+func pageLinks(r *http.Request, page models.Page, s []*models.Snippet) ([]*models.Snippet, *pagination) {
+	p := &pagination{}
+	more := len(s) > listPageSize
+
+	// A cursor beyond either end of the listing (perhaps because snippets
+	// have since expired) leaves only the way back to the start
+	if len(s) == 0 {
+		if page.Before != nil || page.After != nil {
+			p.Prev = cursorURL(r, "", nil)
+		}
+		return s, p
+	}
+
+	switch {
+	case page.After != nil:
+		// Newer pages come back newest first so the extra snippet is the
+		// first one.  Older snippets must exist as the cursor was one.
+		if more {
+			s = s[1:]
+			p.Prev = cursorURL(r, "after", s[0])
+		}
+		p.Next = cursorURL(r, "before", s[len(s)-1])
+	case page.Before != nil:
+		if more {
+			s = s[:listPageSize]
+			p.Next = cursorURL(r, "before", s[len(s)-1])
+		}
+		p.Prev = cursorURL(r, "after", s[0])
+	default:
+		if more {
+			s = s[:listPageSize]
+			p.Next = cursorURL(r, "before", s[len(s)-1])
+		}
+	}
+
+	return s, p
+}
+
+// cursorURL helper returns the URL of the current request with its cursor
+// replaced by one of the given direction positioned at a snippet.  A nil
+// snippet removes the cursor, giving the URL of the first page.
+func cursorURL(r *http.Request, direction string, s *models.Snippet) string {
+	q := linkQuery(r)
+	q.Del("before")
+	q.Del("after")
+	if s != nil {
+		q.Set(direction, models.CursorFor(s).String())
+	}
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
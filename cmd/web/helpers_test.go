@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+func TestPageLinks(t *testing.T) {
+
+	// Build a full page's worth of snippets plus the extra one fetched to
+	// detect a further page, newest first
+	base := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	var snippets []*models.Snippet
+	for i := listPageSize + 1; i > 0; i-- {
+		snippets = append(snippets, &models.Snippet{ID: i, Created: base.Add(time.Duration(i) * time.Minute)})
+	}
+	cursor := &models.Cursor{Created: base, ID: 99}
+
+	tests := []struct {
+		name      string
+		target    string
+		page      models.Page
+		snippets  []*models.Snippet
+		wantFirst int
+		wantPrev  string
+		wantNext  string
+	}{
+		{"First page", "/", models.Page{}, snippets, 11, "", "/?before=20201217100200-2"},
+		{"Last page", "/", models.Page{}, snippets[:3], 11, "", ""},
+		{"Older page", "/?before=x", models.Page{Before: cursor}, snippets, 11, "/?after=20201217101100-11", "/?before=20201217100200-2"},
+		{"Newer page", "/?after=x", models.Page{After: cursor}, snippets, 10, "/?after=20201217101000-10", "/?before=20201217100100-1"},
+		{"Past the end", "/u?before=x&q=a", models.Page{Before: cursor}, nil, 0, "/u?q=a", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			s, p := pageLinks(r, tt.page, tt.snippets)
+			if len(s) > listPageSize {
+				t.Errorf("want at most %d snippets; got %d", listPageSize, len(s))
+			}
+			if len(s) > 0 && s[0].ID != tt.wantFirst {
+				t.Errorf("want first snippet %d; got %d", tt.wantFirst, s[0].ID)
+			}
+			if p.Prev != tt.wantPrev {
+				t.Errorf("want prev %q; got %q", tt.wantPrev, p.Prev)
+			}
+			if p.Next != tt.wantNext {
+				t.Errorf("want next %q; got %q", tt.wantNext, p.Next)
+			}
+		})
+	}
+}
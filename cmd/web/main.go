@@ -8,32 +8,50 @@
 //TODO: Add captha support
 //TODO: Add health check and handling for if database is offline
 //TODO: Add a redirect from HTTP to HTTPS
-//TODO: Add ability to close server with Ctrl-C in a nice way
 
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/golangcollege/sessions"
+	"ptodd.org/snippetbox/pkg/blob"
+	"ptodd.org/snippetbox/pkg/keyring"
 	"ptodd.org/snippetbox/pkg/models"
 	"ptodd.org/snippetbox/pkg/models/mysql"
 )
 
 // Config retains passed command-line flags
 type config struct {
-	addr      string
-	staticDir string
-	dsn       string
-	secret    string
+	addr              string
+	staticDir         string
+	dsn               string
+	secret            string
+	trashRetention    time.Duration
+	reapInterval      time.Duration
+	viewInterval      time.Duration
+	expiryChoices     expiryChoices
+	maxLifetime       lifetime
+	blobDir           string
+	maxAttachmentSize int64
+	attachmentQuota   int64
+	maxContentSize    int64
+	frameAncestors    originList
+	keyring           string
 }
 
 // Application struct is used for application-wide dependencies
@@ -42,9 +60,67 @@ type application struct {
 	infoLog  *log.Logger
 	session  *sessions.Session
 	snippets interface { // Interface is used here so both mysql and mock models can be used
-		Insert(string, string, string) (int, error)
+		Insert(int, string, []*models.File, string, time.Time, int, int) (int, error)
 		Get(int) (*models.Snippet, error)
-		Latest() ([]*models.Snippet, error)
+		Files(int) ([]*models.File, error)
+		WriteContent(int, io.Writer) error
+		BySlug(string) (*models.Snippet, error)
+		View(int) (*models.Snippet, error)
+		AddViews(map[int]int) error
+		Latest(models.Page) ([]*models.Snippet, error)
+		Search(models.SearchQuery) ([]*models.Snippet, error)
+		ByTag(string, models.Page) ([]*models.Snippet, error)
+		ByUser(int, models.Page) ([]*models.Snippet, error)
+		Forks(int, int) ([]*models.Snippet, error)
+		Identical(int, int) (int, error)
+		Starred(int, models.Page) ([]*models.Snippet, error)
+		InCollection(int, int, models.Page) ([]*models.Snippet, error)
+		Update(int, int, string, []*models.File, string) error
+		Revisions(int) ([]*models.Revision, error)
+		Revision(int, int) (*models.Revision, error)
+		Delete(int, int) error
+		Restore(int, int) error
+		Trash(int) ([]*models.Snippet, error)
+		Purge(int, int) error
+		PurgeTrash(time.Duration) (int, error)
+		ReapExpired(int) (int, error)
+		Tombstone(int) (*models.Tombstone, error)
+		TombstoneBySlug(string) (*models.Tombstone, error)
+	}
+	attachments interface { // Interface is used here so both mysql and mock models can be used
+		Insert(int, int, string, string, string, int64) (int, error)
+		Get(int) (*models.Attachment, error)
+		ForSnippet(int) ([]*models.Attachment, error)
+		Usage(int) (int64, error)
+		Orphans(int) ([]*models.Attachment, error)
+		Delete(int) error
+	}
+	blobs       blob.BlobStore
+	collections interface { // Interface is used here so both mysql and mock models can be used
+		Insert(int, string, string) (int, error)
+		Get(int) (*models.Collection, error)
+		BySlug(string) (*models.Collection, error)
+		ByUser(int) ([]*models.Collection, error)
+		ForSnippet(int, int) ([]*models.Collection, error)
+		Update(int, int, string, string) error
+		Delete(int, int) error
+		Add(int, int) error
+		Remove(int, int) error
+	}
+	comments interface { // Interface is used here so both mysql and mock models can be used
+		Insert(*models.Comment) (int, error)
+		Get(int) (*models.Comment, error)
+		ForSnippet(int) ([]*models.Comment, error)
+		Delete(int) error
+	}
+	stars interface { // Interface is used here so both mysql and mock models can be used
+		Add(int, int) error
+		Remove(int, int) error
+		Has(int, int) (bool, error)
+	}
+	tags interface { // Interface is used here so both mysql and mock models can be used
+		Set(int, []string) error
+		ForSnippet(int) ([]string, error)
 	}
 	users interface { // Interface is used here so both mysql and mock models can be used
 		Insert(string, string, string) error
@@ -52,6 +128,7 @@ type application struct {
 		Get(int) (*models.User, error)
 	}
 	templateCache map[string]*template.Template
+	views         *viewCounter // Views of snippets not yet written to the database
 }
 
 // ContextKey is used to define our own unique key for storage and retrieval of user details
@@ -69,6 +146,18 @@ func init() {
 	flag.StringVar(&cfg.staticDir, "static-dir", "./ui/static", "Path to static assets")
 	flag.StringVar(&cfg.dsn, "dsn", "web:snippet@/snippetbox?parseTime=true", "MySQL data source name")
 	flag.StringVar(&cfg.secret, "secret", "2pf1tyu8dT19yjHhuNozkSY67KJnR4lG", "Secret key")
+	flag.DurationVar(&cfg.trashRetention, "trash-retention", 30*24*time.Hour, "How long deleted snippets stay in the trash")
+	flag.DurationVar(&cfg.reapInterval, "reap-interval", 10*time.Minute, "How often expired snippets are removed")
+	flag.DurationVar(&cfg.viewInterval, "view-interval", 30*time.Second, "How often counted snippet views are written to the database")
+	cfg.expiryChoices.Set("1y,1w,1d,1h,10m,never")
+	flag.Var(&cfg.expiryChoices, "expiry-choices", "Comma-separated expiry periods offered for new snippets, the first being the default (e.g. 30m, 12h, 1d, 2w, 1y or never)")
+	flag.Var(&cfg.maxLifetime, "max-lifetime", "Longest a new snippet may be kept for as a period (e.g. 1y), or 0 for no limit")
+	flag.StringVar(&cfg.blobDir, "blob-dir", "./data/blobs", "Path to the directory storing attachments")
+	flag.Int64Var(&cfg.maxAttachmentSize, "max-attachment-size", 10<<20, "Largest file in bytes which may be attached to a snippet")
+	flag.Int64Var(&cfg.attachmentQuota, "attachment-quota", 100<<20, "Total size in bytes of the files each user may attach")
+	flag.Int64Var(&cfg.maxContentSize, "max-content-size", 2<<20, "Largest content in bytes of each file of a snippet")
+	flag.Var(&cfg.frameAncestors, "frame-ancestors", "Comma-separated origins allowed to embed snippets in frames (e.g. https://wiki.example.com)")
+	flag.StringVar(&cfg.keyring, "keyring", "", "Path to the keyring file of keys encrypting snippet content, which can then no longer be searched, or blank to store it unencrypted")
 	flag.Parse()
 }
 
@@ -78,6 +167,11 @@ func main() {
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
+	// Check the expiry choices are consistent with the maximum lifetime
+	if err := checkExpiryConfig(cfg.expiryChoices, cfg.maxLifetime); err != nil {
+		errorLog.Fatal(err)
+	}
+
 	// Initialize database connection pool (MySQL)
 	db, err := openDB(cfg.dsn)
 	if err != nil {
@@ -85,6 +179,23 @@ func main() {
 	}
 	defer db.Close()
 
+	// Load the keys for encrypting snippet content, if any.  Encrypted
+	// content is left out of the full-text index, so searches only match
+	// snippet titles.
+	var keys *keyring.Keyring
+	if cfg.keyring != "" {
+		if keys, err = keyring.Load(cfg.keyring); err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Print("WARNING: snippet content is encrypted, so searches only match titles")
+	}
+
+	// Initialize the store for attachments
+	blobs, err := blob.NewFileStore(cfg.blobDir)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
 	// Initialize a new template cache
 	templateCache, err := newTemplateCache("./ui/html/")
 	if err != nil {
@@ -105,11 +216,42 @@ func main() {
 		infoLog:       infoLog,
 		errorLog:      errorLog,
 		session:       session,
-		snippets:      &mysql.SnippetModel{DB: db},
+		snippets:      &mysql.SnippetModel{DB: db, Keys: keys},
+		attachments:   &mysql.AttachmentModel{DB: db},
+		blobs:         blobs,
+		collections:   &mysql.CollectionModel{DB: db},
+		comments:      &mysql.CommentModel{DB: db},
+		stars:         &mysql.StarModel{DB: db},
+		tags:          &mysql.TagModel{DB: db},
 		users:         &mysql.UserModel{DB: db},
 		templateCache: templateCache,
+		views:         newViewCounter(),
 	}
 
+	// Start the background workers: purging snippets which have outlived
+	// their time in the trash, reaping expired ones, removing the attachments
+	// they leave behind and writing out snippet views.  Cancelling the
+	// context stops them, after the last views have been written.
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(4)
+	go func() {
+		defer workers.Done()
+		app.purgeTrash(ctx, time.Hour, cfg.trashRetention)
+	}()
+	go func() {
+		defer workers.Done()
+		app.reapExpired(ctx, cfg.reapInterval)
+	}()
+	go func() {
+		defer workers.Done()
+		app.removeOrphans(ctx, cfg.reapInterval)
+	}()
+	go func() {
+		defer workers.Done()
+		app.flushViews(ctx, cfg.viewInterval)
+	}()
+
 	// Custom TLS settings
 	// TODO: Consider restricting to only support strong cipher suites understanding
 	// doing so will reduce the range of supported browsers
@@ -130,10 +272,33 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// Shut down gracefully on an interrupt or termination signal: stop
+	// accepting requests, let those in flight finish, then stop the workers
+	shutdown := make(chan error, 1)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+		infoLog.Printf("Shutting down on %s", s)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
 	// Launch server
 	infoLog.Printf("Starting server on %s\n", cfg.addr)
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	if !errors.Is(err, http.ErrServerClosed) {
+		errorLog.Fatal(err)
+	}
+	if err = <-shutdown; err != nil {
+		errorLog.Print(err)
+	}
+
+	stopWorkers()
+	workers.Wait()
+	infoLog.Print("Server stopped")
 }
 
 // openDB is a wrapper for sql.Open()
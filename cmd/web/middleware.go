@@ -17,6 +17,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/nosurf"
 	"ptodd.org/snippetbox/pkg/models"
@@ -34,6 +35,26 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// frameAncestors provides middleware that lets the pages it wraps be framed
+// by the given origins instead, overriding the denial set by secureHeaders.
+// With no origins framing stays denied.
+// c.f. https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Security-Policy/frame-ancestors
+func frameAncestors(origins []string) func(http.Handler) http.Handler {
+	policy := "frame-ancestors 'none'"
+	if len(origins) > 0 {
+		policy = "frame-ancestors " + strings.Join(origins, " ")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(origins) > 0 {
+				w.Header().Del("X-Frame-Options")
+			}
+			w.Header().Set("Content-Security-Policy", policy)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // logRequest provides a middleware component to log all API requests
 // NOTE: Implementing this as a method under the application object grants
 // the function access to application dependencies such as information logger
@@ -81,6 +102,17 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// limitBody middleware caps the size of request bodies.  It must come before
+// anything which reads the body, such as the CSRF check which parses forms.
+func limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // noSurf provides middleware that protects againt CSRF attacks when a user is using
 // a browser that does not support SameSite cookie attributes
 func noSurf(next http.Handler) http.Handler {
@@ -61,3 +61,42 @@ func TestSecureHeaders(t *testing.T) {
 		t.Errorf("want body to equal %q", "OK")
 	}
 }
+
+func TestFrameAncestors(t *testing.T) {
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	tests := []struct {
+		name             string
+		origins          []string
+		wantFrameOptions string
+		wantPolicy       string
+	}{
+		{"None", nil, "deny", "frame-ancestors 'none'"},
+		{"Some", []string{"https://wiki.example.com", "https://*.example.org"}, "", "frame-ancestors https://wiki.example.com https://*.example.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequest(http.MethodGet, "/snippet/1/embed", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Framing is denied for every page before the route's own
+			// policy applies
+			secureHeaders(frameAncestors(tt.origins)(next)).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			if got := rs.Header.Get("X-Frame-Options"); got != tt.wantFrameOptions {
+				t.Errorf("want X-Frame-Options %q; got %q", tt.wantFrameOptions, got)
+			}
+			if got := rs.Header.Get("Content-Security-Policy"); got != tt.wantPolicy {
+				t.Errorf("want Content-Security-Policy %q; got %q", tt.wantPolicy, got)
+			}
+		})
+	}
+}
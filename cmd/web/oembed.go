@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+// Consumers of oEmbed, such as chat and wiki tools, find the oEmbed endpoint
+// from a link in the head of a snippet's page and fetch a description of the
+// snippet from it, including the HTML to show it: a frame of its embed page.
+// c.f. https://oembed.com
+
+// The size of the frame is estimated from the snippet's content using the
+// heights of the parts of the embed page, in pixels.  Markdown files are
+// given a fixed height.
+const (
+	oembedWidth          = 800
+	oembedMaxHeight      = 600
+	oembedMetadataHeight = 54
+	oembedLineHeight     = 27
+	oembedMarkdownHeight = 270
+)
+
+// errPrivateSnippet is returned by oembedSnippet for a private snippet given
+// by its slug, for which the oEmbed spec has providers respond 401
+// Unauthorized.  Whoever holds the slug already knows the snippet exists.
+var errPrivateSnippet = errors.New("private snippet")
+
+// oembedResponse is an oEmbed response of the "rich" type
+type oembedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name,omitempty"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	CacheAge     int    `json:"cache_age,omitempty"`
+}
+
+// oembed handler describes the snippet whose page is given by the 'url'
+// query parameter for embedding elsewhere.  Only public snippets, and
+// unlisted snippets given by their slug, are described.  Private snippets
+// given by their slug are unauthorized and anything else is not found, as it
+// would be on the site to someone not logged in.
+func (app *application) oembed(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		app.clientError(w, http.StatusNotImplemented)
+		return
+	}
+	maxWidth, okWidth := dimension(query.Get("maxwidth"))
+	maxHeight, okHeight := dimension(query.Get("maxheight"))
+	if query.Get("url") == "" || !okWidth || !okHeight {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.oembedSnippet(r, query.Get("url"))
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil && errors.Is(err, errPrivateSnippet) {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	files, err := app.snippets.Files(s.ID)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	s.Files = files
+
+	author := ""
+	if user, err := app.users.Get(s.UserID); err == nil {
+		author = user.Name
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	width, height := oembedWidth, frameHeight(s)
+	if maxWidth > 0 && width > maxWidth {
+		width = maxWidth
+	}
+	if maxHeight > 0 && height > maxHeight {
+		height = maxHeight
+	}
+
+	site := siteURL(r)
+	res := &oembedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		ProviderName: "Snippetbox",
+		ProviderURL:  site + "/",
+		Title:        s.Title,
+		AuthorName:   author,
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s" style="border: none"></iframe>`,
+			html.EscapeString(site+snippetPath(s)+"/embed"), width, height, html.EscapeString(s.Title)),
+		Width:  width,
+		Height: height,
+	}
+
+	// Consumers should not keep showing a snippet after it expires
+	if !s.Expires.IsZero() {
+		if age := int(time.Until(s.Expires) / time.Second); age > 0 {
+			res.CacheAge = age
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// oembedSnippet returns the snippet whose page on this site is at the given
+// URL, errPrivateSnippet if it is private and given by its slug, or
+// models.ErrNoRecord if there is no other which may be embedded
+func (app *application) oembedSnippet(r *http.Request, rawURL string) (*models.Snippet, error) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host != r.Host {
+		return nil, models.ErrNoRecord
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+
+	var s *models.Snippet
+	switch {
+	case strings.HasPrefix(path, "/snippet/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(path, "/snippet/"))
+		if err != nil || id < 1 {
+			return nil, models.ErrNoRecord
+		}
+		if s, err = app.snippets.Get(id); err != nil {
+			return nil, err
+		}
+
+		// IDs are sequential, so which are private must not be told apart
+		// from those that do not exist
+		if s.Visibility != models.Public {
+			return nil, models.ErrNoRecord
+		}
+	case strings.HasPrefix(path, "/s/"):
+		slug := strings.TrimPrefix(path, "/s/")
+		if slug == "" || strings.Contains(slug, "/") {
+			return nil, models.ErrNoRecord
+		}
+		if s, err = app.snippets.BySlug(slug); err != nil {
+			return nil, err
+		}
+		if s.Visibility == models.Private {
+			return nil, errPrivateSnippet
+		}
+	default:
+		return nil, models.ErrNoRecord
+	}
+
+	// Embedding a view-limited snippet would use up its views
+	if s.MaxViews > 0 {
+		return nil, models.ErrNoRecord
+	}
+	return s, nil
+}
+
+// dimension parses a maximum width or height given to the oEmbed endpoint,
+// returning zero for none and false if it is malformed
+func dimension(s string) (int, bool) {
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// frameHeight estimates the height of the embed page of a snippet, up to
+// oembedMaxHeight beyond which it scrolls
+func frameHeight(s *models.Snippet) int {
+	height := 2 * oembedMetadataHeight
+	for _, f := range s.Files {
+		if len(s.Files) > 1 {
+			height += oembedMetadataHeight
+		}
+		if f.Language == "markdown" {
+			height += oembedMarkdownHeight
+		} else {
+			height += lineCount(f.Content) * oembedLineHeight
+		}
+	}
+	if height > oembedMaxHeight {
+		height = oembedMaxHeight
+	}
+	return height
+}
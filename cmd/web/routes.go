@@ -18,17 +18,63 @@ func (app *application) routes() http.Handler {
 	// session state
 	dynamicMiddleware := alice.New(app.session.Enable, noSurf, app.authenticate)
 
+	// Set-up middleware chain for pages which other sites may frame
+	embedMiddleware := dynamicMiddleware.Append(frameAncestors(cfg.frameAncestors))
+
 	// Initialize new server mux
 	mux := pat.New()
 
 	// Register application page routes
 	//TODO: The endpoints that should not be used by authenticated users (signup and login) should also be protected
 	mux.Get("/", dynamicMiddleware.ThenFunc(app.home))
+	mux.Get("/search", dynamicMiddleware.ThenFunc(app.search))
 
 	// Register snippet pages
 	mux.Get("/snippet/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippetForm))
-	mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippet))
+	mux.Post("/snippet/create", alice.New(limitBody(maxCreateRequest())).Extend(dynamicMiddleware).Append(app.requireAuthentication).ThenFunc(app.createSnippet))
 	mux.Get("/snippet/:id", dynamicMiddleware.ThenFunc(app.showSnippet))
+	mux.Get("/snippet/:id/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editSnippetForm))
+	mux.Post("/snippet/:id/edit", alice.New(limitBody(maxEditRequest())).Extend(dynamicMiddleware).Append(app.requireAuthentication).ThenFunc(app.editSnippet))
+	mux.Post("/snippet/:id/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteSnippet))
+	mux.Post("/snippet/:id/restore", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.restoreSnippet))
+	mux.Post("/snippet/:id/purge", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.purgeSnippet))
+	mux.Get("/snippet/:id/history", dynamicMiddleware.ThenFunc(app.snippetHistory))
+	mux.Get("/snippet/:id/diff", dynamicMiddleware.ThenFunc(app.snippetDiff))
+	mux.Get("/snippet/:id/raw", dynamicMiddleware.ThenFunc(app.rawSnippet))
+	mux.Get("/snippet/:id/download", dynamicMiddleware.ThenFunc(app.downloadSnippet))
+	mux.Get("/snippet/:id/zip", dynamicMiddleware.ThenFunc(app.zipSnippet))
+	mux.Get("/snippet/:id/embed", embedMiddleware.ThenFunc(app.embedSnippet))
+	mux.Get("/snippet/:id/attachment/:aid", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.showAttachment))
+	mux.Post("/snippet/:id/star", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.starSnippet))
+	mux.Post("/snippet/:id/unstar", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.unstarSnippet))
+	mux.Post("/snippet/:id/collect", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.collectSnippet))
+	mux.Post("/snippet/:id/uncollect", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.uncollectSnippet))
+	mux.Post("/snippet/:id/comment", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.postComment))
+	mux.Post("/snippet/:id/comment/:cid/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteComment))
+	mux.Get("/s/:slug", dynamicMiddleware.ThenFunc(app.showSnippet))
+	mux.Get("/s/:slug/raw", dynamicMiddleware.ThenFunc(app.rawSnippet))
+	mux.Get("/s/:slug/download", dynamicMiddleware.ThenFunc(app.downloadSnippet))
+	mux.Get("/s/:slug/zip", dynamicMiddleware.ThenFunc(app.zipSnippet))
+	mux.Get("/s/:slug/embed", embedMiddleware.ThenFunc(app.embedSnippet))
+	mux.Get("/s/:slug/attachment/:aid", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.showAttachment))
+	mux.Post("/s/:slug/star", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.starSnippet))
+	mux.Post("/s/:slug/unstar", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.unstarSnippet))
+	mux.Post("/s/:slug/collect", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.collectSnippet))
+	mux.Post("/s/:slug/uncollect", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.uncollectSnippet))
+	mux.Post("/s/:slug/comment", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.postComment))
+	mux.Post("/s/:slug/comment/:cid/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteComment))
+
+	// Register collection pages
+	mux.Get("/collection/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createCollectionForm))
+	mux.Post("/collection/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createCollection))
+	mux.Get("/collection/:id", dynamicMiddleware.ThenFunc(app.showCollection))
+	mux.Get("/collection/:id/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editCollectionForm))
+	mux.Post("/collection/:id/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editCollection))
+	mux.Post("/collection/:id/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteCollection))
+	mux.Get("/c/:slug", dynamicMiddleware.ThenFunc(app.showCollection))
+
+	// Register tag listing pages
+	mux.Get("/tag/:name", dynamicMiddleware.ThenFunc(app.tagSnippets))
 
 	// Register user management pages
 	mux.Get("/user/signup", dynamicMiddleware.ThenFunc(app.signupUserForm))
@@ -39,6 +85,15 @@ func (app *application) routes() http.Handler {
 	mux.Post("/user/login", dynamicMiddleware.ThenFunc(app.loginUser))
 	mux.Post("/user/logout", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.logoutUser))
 
+	// Register pages for an authenticated user's own content
+	mux.Get("/user/snippets", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userSnippets))
+	mux.Get("/user/trash", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userTrash))
+	mux.Get("/user/collections", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userCollections))
+	mux.Get("/user/starred", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userStarred))
+
+	// Describe snippets for embedding by oEmbed consumers
+	mux.Get("/oembed", http.HandlerFunc(app.oembed))
+
 	// Handle a health checker
 	mux.Get("/ping", http.HandlerFunc(ping))
 
@@ -1,30 +1,80 @@
 package main
 
 import (
+	"fmt"
+	"html"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"ptodd.org/snippetbox/pkg/forms"
+	syntax "ptodd.org/snippetbox/pkg/highlight"
+	"ptodd.org/snippetbox/pkg/markdown"
 	"ptodd.org/snippetbox/pkg/models"
 )
 
 // templateData acts as a holding structure for any dynamic data passed to
 // HTML templates. 'CurrentYear' is an example of common dynamic data
 type templateData struct {
-	CSRFToken       string
-	CurrentYear     int
-	Flash           string
-	Form            *forms.Form
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	IsAuthenticated bool
+	Attachments         []*models.Attachment
+	AuthenticatedUserID int
+	Code                [][]codeLine // the lines of each file of Snippet
+	Collection          *models.Collection
+	Collections         []*models.Collection
+	Comments            []*models.Comment
+	CSRFToken           string
+	CurrentYear         int
+	Diffs               []*fileDiff // the changed files between FromRevision and ToRevision
+	Flash               string
+	Forks               []*models.Snippet
+	Form                *forms.Form
+	MoreCollections     []*models.Collection // those Snippet could be added to
+	OEmbedURL           string               // of the page, if it may be described by oEmbed
+	FromRevision        *models.Revision
+	Identical           int // the number of other snippets with the same content as Snippet
+	Pagination          *pagination
+	Parent              *models.Snippet
+	Revisions           []*models.Revision
+	SiteURL             string
+	Snippet             *models.Snippet
+	Snippets            []*models.Snippet
+	Starred             bool
+	Tag                 string
+	TitlesOnly          bool // searches only match titles, as content is encrypted
+	Tombstone           *models.Tombstone
+	ToRevision          *models.Revision
+	Views               int
+	IsAuthenticated     bool
+}
+
+// pagination holds the links to the neighbouring pages of a listing.  An empty
+// link means there is no page in that direction.
+type pagination struct {
+	Prev string
+	Next string
 }
 
 // Initialize a tempate.FuncMap object for registering custom functions for
 // use inside templates
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"commentList":   newCommentList,
+	"excerpt":       excerpt,
+	"expiryChoices": func() expiryChoices { return cfg.expiryChoices },
+	"expiryDate":    expiryDate,
+	"formFiles":     formFiles,
+	"fileSize":      forms.FormatSize,
+	"snippetPath":   snippetPath,
+	"highlight":     highlight,
+	"humanDate":     humanDate,
+	"languageLabel": syntax.Label,
+	"languages":     func() []syntax.Language { return syntax.Languages },
+	"markdown":      markdown.Render,
+	"syntax":        syntax.HTML,
 }
 
 // humanDate returns a human-friendly formated string representation of a
@@ -37,6 +87,94 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// expiryDate returns a human-friendly representation of a snippet's expiry
+// time, which is zero for snippets which never expire
+func expiryDate(t time.Time) string {
+	if t.IsZero() {
+		return "Never"
+	}
+	return humanDate(t)
+}
+
+// highlight returns text HTML-escaped with every occurrence of the words in a
+// search query wrapped in <mark> tags.  Matching is case-insensitive.
+func highlight(text, query string) string {
+	rx := termsRegexp(query)
+	if rx == nil {
+		return html.EscapeString(text)
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, loc := range rx.FindAllStringIndex(text, -1) {
+		sb.WriteString(html.EscapeString(text[last:loc[0]]))
+		sb.WriteString("<mark>")
+		sb.WriteString(html.EscapeString(text[loc[0]:loc[1]]))
+		sb.WriteString("</mark>")
+		last = loc[1]
+	}
+	sb.WriteString(html.EscapeString(text[last:]))
+
+	return sb.String()
+}
+
+// excerpt returns a highlighted extract of at most width characters of text
+// beginning shortly before the first occurrence of any word in a search query
+func excerpt(text, query string, width int) string {
+
+	// Locate the first match as a character (rather than byte) offset
+	start := 0
+	if rx := termsRegexp(query); rx != nil {
+		if loc := rx.FindStringIndex(text); loc != nil {
+			start = utf8.RuneCountInString(text[:loc[0]])
+		}
+	}
+
+	// Show a little leading context before the match, but use the whole
+	// width available when the match is close to the end of the text
+	runes := []rune(text)
+	from := start - width/4
+	if from > len(runes)-width {
+		from = len(runes) - width
+	}
+	if from < 0 {
+		from = 0
+	}
+	to := from + width
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	out := string(runes[from:to])
+	if from > 0 {
+		out = "…" + out
+	}
+	if to < len(runes) {
+		out += "…"
+	}
+
+	return highlight(out, query)
+}
+
+// termsRegexp compiles a case-insensitive expression matching any of the
+// words in a search query, or returns nil if the query contains no words
+func termsRegexp(query string) *regexp.Regexp {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return nil
+	}
+
+	// Prefer the longest match where one word is a prefix of another
+	sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+
+	return regexp.MustCompile("(?i)" + strings.Join(words, "|"))
+}
+
 // newTemplateCache creates a new template cache
 func newTemplateCache(dir string) (map[string]*template.Template, error) {
 
@@ -79,3 +217,13 @@ func newTemplateCache(dir string) (map[string]*template.Template, error) {
 
 	return cache, nil
 }
+
+// snippetPath returns the path of a snippet's page which others who can see
+// it may use: its slug for unlisted snippets and otherwise its ID.  Paths for
+// its raw content, attachments etc. extend this.
+func snippetPath(s *models.Snippet) string {
+	if s.Visibility == models.Unlisted {
+		return "/s/" + s.Slug
+	}
+	return fmt.Sprintf("/snippet/%d", s.ID)
+}
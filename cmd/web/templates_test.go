@@ -1,7 +1,9 @@
 package main
 
 import (
+	"strings"
 	"testing"
+	"text/template"
 	"time"
 )
 
@@ -52,3 +54,66 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestHighlight(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  string
+	}{
+		{"No terms", "An old pond", "", "An old pond"},
+		{"Single term", "An old pond", "pond", "An old <mark>pond</mark>"},
+		{"Case insensitive", "An Old pond", "old", "An <mark>Old</mark> pond"},
+		{"Multiple terms", "An old pond", "old pond", "An <mark>old</mark> <mark>pond</mark>"},
+		{"Escapes HTML", "<b>old</b>", "old", "&lt;b&gt;<mark>old</mark>&lt;/b&gt;"},
+		{"Ignores punctuation", "a.b", ".", "a.b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlight(tt.text, tt.query); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		width int
+		want  string
+	}{
+		{"Short text", "An old pond", "pond", 20, "An old <mark>pond</mark>"},
+		{"Leading context", "one two three four five six", "five", 8, "…r <mark>five</mark> s…"},
+		{"No match", "one two three", "zebra", 7, "one two…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := excerpt(tt.text, tt.query, tt.width); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSyntax(t *testing.T) {
+
+	ts := template.Must(template.New("code").Funcs(functions).Parse(`{{syntax .Language .Content}}`))
+	var sb strings.Builder
+	err := ts.Execute(&sb, map[string]string{"Language": "go", "Content": `x := "<a>"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "x := <span class='hl-str'>&#34;&lt;a&gt;&#34;</span>"
+	if sb.String() != want {
+		t.Errorf("want %q; got %q", want, sb.String())
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"html"
 	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
@@ -53,8 +55,15 @@ func newTestApplication(t *testing.T) *application {
 		infoLog:       log.New(ioutil.Discard, "", 0),
 		session:       session,
 		snippets:      &mock.SnippetModel{},
+		attachments:   &mock.AttachmentModel{},
+		blobs:         &mock.BlobStore{},
+		collections:   &mock.CollectionModel{},
+		comments:      &mock.CommentModel{},
+		stars:         &mock.StarModel{},
+		tags:          &mock.TagModel{},
 		templateCache: templateCache,
 		users:         &mock.UserModel{},
+		views:         newViewCounter(),
 	}
 }
 
@@ -127,3 +136,60 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	// Return the response status, headers and body.
 	return rs.StatusCode, rs.Header, body
 }
+
+// postMultipart sends a multipart form to the test server, as a browser does
+// for forms with file inputs.  Each file is given as its content keyed by the
+// field and file name, e.g. {"attachments": {"a.log": "..."}}.
+func (ts *testServer) postMultipart(t *testing.T, urlPath string, form url.Values, files map[string]map[string]string) (int, http.Header, []byte) {
+
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	for field, values := range form {
+		for _, v := range values {
+			if err := mw.WriteField(field, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for field, contents := range files {
+		for name, content := range contents {
+			fw, err := mw.CreateFormFile(field, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = fw.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := ts.Client().Post(ts.URL+urlPath, mw.FormDataContentType(), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	body, err := ioutil.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, rs.Header, body
+}
+
+// login authenticates the test server's client as the mock user Alice by
+// fetching a CSRF token from the login page and then posting her credentials.
+// The session cookie is retained in the client's cookie jar for subsequent
+// requests.
+func (ts *testServer) login(t *testing.T) {
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login: want %d; got %d", http.StatusSeeOther, code)
+	}
+}
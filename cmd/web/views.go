@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/gob"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// viewWindow is how long repeat views of a snippet from the same session are
+// ignored for
+const viewWindow = 30 * time.Minute
+
+// maxRecentViews is the most snippets remembered as recently viewed in a
+// session, which keeps the session cookie small
+const maxRecentViews = 50
+
+func init() {
+	// Sessions are gob encoded, which needs to know the concrete types kept
+	// in them
+	gob.Register(map[int]int64{})
+}
+
+// viewCounter buffers the number of times snippets have been viewed so they
+// can be written to the database in batches rather than one UPDATE per view.
+// It is safe for concurrent use.
+type viewCounter struct {
+	mu     sync.Mutex
+	counts map[int]int // by snippet ID
+}
+
+// newViewCounter returns an empty counter
+func newViewCounter() *viewCounter {
+	return &viewCounter{counts: map[int]int{}}
+}
+
+// add counts a view of a snippet
+func (c *viewCounter) add(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[id]++
+}
+
+// pending returns the number of views of a snippet not yet written
+func (c *viewCounter) pending(id int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[id]
+}
+
+// take returns the views counted so far, leaving the counter empty
+func (c *viewCounter) take() map[int]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = map[int]int{}
+	return counts
+}
+
+// restore puts back views returned by take which could not be written, so
+// they are retried with the next batch
+func (c *viewCounter) restore(counts map[int]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, n := range counts {
+		c.counts[id] += n
+	}
+}
+
+// firstView reports whether the current session has not viewed a snippet
+// within the view window, recording the view in the session if so
+func (app *application) firstView(r *http.Request, id int, now time.Time) bool {
+
+	// Forget views which have fallen out of the window
+	recent, _ := app.session.Get(r, "recentViews").(map[int]int64)
+	fresh := map[int]int64{}
+	for viewed, at := range recent {
+		if now.Sub(time.Unix(at, 0)) < viewWindow {
+			fresh[viewed] = at
+		}
+	}
+	if _, ok := fresh[id]; ok {
+		return false
+	}
+	fresh[id] = now.Unix()
+
+	// Forget the oldest views once there are too many to keep
+	if len(fresh) > maxRecentViews {
+		ids := make([]int, 0, len(fresh))
+		for viewed := range fresh {
+			ids = append(ids, viewed)
+		}
+		sort.Slice(ids, func(i, j int) bool { return fresh[ids[i]] < fresh[ids[j]] })
+		for _, viewed := range ids[:len(ids)-maxRecentViews] {
+			delete(fresh, viewed)
+		}
+	}
+
+	app.session.Put(r, "recentViews", fresh)
+	return true
+}
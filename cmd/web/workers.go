@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// purgeTrash periodically and permanently removes snippets which have been in
+// the trash for longer than the retention period.  It is intended to be run in
+// its own goroutine and returns once the context is cancelled.
+func (app *application) purgeTrash(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := app.snippets.PurgeTrash(retention)
+		if err != nil {
+			app.errorLog.Printf("purging trash: %s", err)
+		} else if n > 0 {
+			app.infoLog.Printf("Purged %d snippets from the trash", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// orphanBatchSize is the number of orphaned attachments removed at a time
+const orphanBatchSize = 100
+
+// removeOrphans periodically deletes the blobs of attachments left behind by
+// snippets which have been removed, followed by their records.  It is
+// intended to be run in its own goroutine and returns once the context is
+// cancelled.
+func (app *application) removeOrphans(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		total := 0
+	batches:
+		for ctx.Err() == nil {
+			orphans, err := app.attachments.Orphans(orphanBatchSize)
+			if err != nil {
+				app.errorLog.Printf("finding orphaned attachments: %s", err)
+				break
+			}
+			for _, a := range orphans {
+				err = app.blobs.Delete(a.Key)
+				if err == nil {
+					err = app.attachments.Delete(a.ID)
+				}
+				if err != nil {
+					app.errorLog.Printf("removing orphaned attachment %d: %s", a.ID, err)
+					break batches
+				}
+				total++
+			}
+			if len(orphans) < orphanBatchSize {
+				break
+			}
+		}
+		if total > 0 {
+			app.infoLog.Printf("Removed %d orphaned attachments", total)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reapBatchSize is the number of expired snippets removed in each transaction
+const reapBatchSize = 100
+
+// reapExpired periodically and permanently removes snippets which have
+// expired, leaving tombstones behind.  Snippets are removed in batches to keep
+// transactions short; batches are repeated until no expired snippets remain.
+// It is intended to be run in its own goroutine and returns once the context
+// is cancelled, finishing any batch in progress first.
+func (app *application) reapExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		total := 0
+		for ctx.Err() == nil {
+			n, err := app.snippets.ReapExpired(reapBatchSize)
+			if err != nil {
+				app.errorLog.Printf("reaping expired snippets: %s", err)
+				break
+			}
+			total += n
+			if n < reapBatchSize {
+				break
+			}
+		}
+		if total > 0 {
+			app.infoLog.Printf("Reaped %d expired snippets", total)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushViews periodically writes the views counted in memory to the
+// database, retrying any which fail with the next batch.  It is intended to
+// be run in its own goroutine and returns once the context is cancelled,
+// writing the views counted up to then first.
+func (app *application) flushViews(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			app.writeViews()
+			return
+		case <-ticker.C:
+			app.writeViews()
+		}
+	}
+}
+
+// writeViews writes the views counted in memory so far to the database
+func (app *application) writeViews() {
+	counts := app.views.take()
+	if len(counts) == 0 {
+		return
+	}
+	if err := app.snippets.AddViews(counts); err != nil {
+		app.errorLog.Printf("writing snippet views: %s", err)
+		app.views.restore(counts)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkersStop(t *testing.T) {
+
+	app := newTestApplication(t)
+
+	workers := map[string]func(context.Context){
+		"purgeTrash":    func(ctx context.Context) { app.purgeTrash(ctx, time.Millisecond, time.Hour) },
+		"reapExpired":   func(ctx context.Context) { app.reapExpired(ctx, time.Millisecond) },
+		"removeOrphans": func(ctx context.Context) { app.removeOrphans(ctx, time.Millisecond) },
+		"flushViews":    func(ctx context.Context) { app.flushViews(ctx, time.Millisecond) },
+	}
+
+	for name, worker := range workers {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				worker(ctx)
+				close(done)
+			}()
+
+			// Let the worker run a few times before stopping it
+			time.Sleep(5 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("worker did not stop")
+			}
+		})
+	}
+}
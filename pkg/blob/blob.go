@@ -0,0 +1,48 @@
+// Package blob stores opaque byte streams, such as the attachments of
+// snippets, under unguessable keys.  Storage is reached through the BlobStore
+// interface so the local filesystem can later be swapped for an object store.
+
+package blob
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// Errors returned by stores
+var (
+	ErrNotFound   = errors.New("blob: not found")
+	ErrInvalidKey = errors.New("blob: invalid key")
+)
+
+// BlobStore is implemented by each kind of blob storage
+type BlobStore interface {
+	// Put stores everything read from r under the key, replacing any blob
+	// already stored there.  A failed Put leaves nothing behind.
+	Put(key string, r io.Reader) error
+
+	// Open returns a reader for the blob stored under the key, or
+	// ErrNotFound.  The caller must close it.
+	Open(key string) (io.ReadCloser, error)
+
+	// Delete removes the blob stored under the key.  Deleting a blob which
+	// does not exist is not an error.
+	Delete(key string) error
+}
+
+// keyRX matches the keys accepted by stores, which are safe to use as file
+// names and in URLs
+var keyRX = regexp.MustCompile(`^[A-Za-z0-9_-]{4,128}$`)
+
+// NewKey returns a new random key for a blob.  Its 192 bits make collisions
+// and guessing infeasible.
+func NewKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,90 @@
+package blob
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore is a BlobStore keeping each blob in a file beneath a directory.
+// Files are spread across subdirectories named after the first two
+// characters of their keys to keep directories small.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store using the directory, creating it if need be
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the name of the file holding the blob with the key
+func (s *FileStore) path(key string) (string, error) {
+	if !keyRX.MatchString(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.dir, key[:2], key), nil
+}
+
+// Put implements BlobStore.  The blob is written to a temporary file which is
+// renamed into place once complete, so readers never see part of a blob.
+func (s *FileStore) Put(key string, r io.Reader) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+// Open implements BlobStore
+func (s *FileStore) Open(key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Delete implements BlobStore
+func (s *FileStore) Delete(key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
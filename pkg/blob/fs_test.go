@@ -0,0 +1,64 @@
+package blob
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A blob can be read back once stored, and replaced
+	for _, want := range []string{"first", "second"} {
+		if err = s.Put(key, strings.NewReader(want)); err != nil {
+			t.Fatal(err)
+		}
+		r, err := s.Open(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("want %q; got %q", want, got)
+		}
+	}
+
+	// Once deleted it is gone, and deleting it again is harmless
+	for i := 0; i < 2; i++ {
+		if err = s.Delete(key); err != nil {
+			t.Errorf("want no error deleting; got %s", err)
+		}
+	}
+	if _, err = s.Open(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want %v; got %v", ErrNotFound, err)
+	}
+
+	// Keys which could escape the directory are refused
+	for _, key := range []string{"", "../../etc/passwd", "a/b/c/d", ".."} {
+		if err = s.Put(key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: want %v; got %v", key, ErrInvalidKey, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package diff computes line-based differences between two texts and groups
+// them into unified-diff style hunks.
+//
+// c.f. http://www.xmailserver.org/diff2.pdf (Myers, "An O(ND) Difference
+// Algorithm and Its Variations")
+
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind identifies whether a line is shared by both texts, only present in the
+// new text, or only present in the old text
+type Kind int
+
+// Line kinds
+const (
+	Equal Kind = iota
+	Insert
+	Delete
+)
+
+// String returns a lower-case name for the kind which is suitable for use as
+// part of a CSS class name
+func (k Kind) String() string {
+	switch k {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+// Prefix returns the single character unified diffs place before a line
+func (k Kind) Prefix() string {
+	switch k {
+	case Insert:
+		return "+"
+	case Delete:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+// Line is a single line of a diff
+type Line struct {
+	Kind Kind
+	Text string
+}
+
+// String renders the line as it would appear in a unified diff
+func (l Line) String() string {
+	return l.Kind.Prefix() + l.Text
+}
+
+// Hunk is a run of changed lines surrounded by unchanged context lines.  Line
+// numbers are one-based as in a unified diff header.
+type Hunk struct {
+	FromLine  int
+	FromCount int
+	ToLine    int
+	ToCount   int
+	Lines     []Line
+}
+
+// Header returns the "@@ -l,s +l,s @@" range line for the hunk
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.FromLine, h.FromCount, h.ToLine, h.ToCount)
+}
+
+// Lines splits a text into lines, ignoring a single trailing newline and
+// normalizing Windows line endings
+func Lines(s string) []string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// Compare returns the full sequence of equal, inserted and deleted lines
+// needed to turn text a into text b
+func Compare(a, b string) []Line {
+	return compare(Lines(a), Lines(b))
+}
+
+// Hunks returns the differences between text a and text b grouped into hunks
+// with up to context unchanged lines either side of each change.  Identical
+// texts produce no hunks.
+func Hunks(a, b string, context int) []Hunk {
+	lines := Compare(a, b)
+
+	// Record the position in each text at which every line starts so hunk
+	// headers can be derived from any slice of the line sequence
+	type position struct{ from, to int }
+	pos := make([]position, len(lines)+1)
+	for i, l := range lines {
+		pos[i+1] = pos[i]
+		if l.Kind != Insert {
+			pos[i+1].from++
+		}
+		if l.Kind != Delete {
+			pos[i+1].to++
+		}
+	}
+
+	// Find the start and end of each hunk by widening every change by the
+	// context size and merging ranges which touch or overlap
+	var hunks []Hunk
+	for i := 0; i < len(lines); {
+		if lines[i].Kind == Equal {
+			i++
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].Kind != Equal {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].Kind == Equal {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				end += context
+				if end > len(lines) {
+					end = len(lines)
+				}
+				break
+			}
+			end = next
+		}
+
+		h := Hunk{
+			FromLine:  pos[start].from + 1,
+			FromCount: pos[end].from - pos[start].from,
+			ToLine:    pos[start].to + 1,
+			ToCount:   pos[end].to - pos[start].to,
+			Lines:     lines[start:end],
+		}
+
+		// An empty range is identified by the line before it
+		if h.FromCount == 0 {
+			h.FromLine--
+		}
+		if h.ToCount == 0 {
+			h.ToLine--
+		}
+
+		hunks = append(hunks, h)
+		i = end
+	}
+
+	return hunks
+}
+
+// Unified renders the differences between text a and text b as a unified
+// diff with the conventional three lines of context
+func Unified(fromName, toName, a, b string) string {
+	hunks := Hunks(a, b, 3)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks {
+		sb.WriteString(h.Header())
+		sb.WriteByte('\n')
+		for _, l := range h.Lines {
+			sb.WriteString(l.String())
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// compare implements the Myers greedy algorithm, recording the furthest
+// reaching path for each edit distance so the shortest edit script can be
+// recovered by walking back from the end of both inputs
+func compare(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n+m == 0 {
+		return nil
+	}
+
+	// v holds the furthest x reached on each diagonal k, offset so that
+	// negative diagonals can be indexed
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // move down (insertion)
+			} else {
+				x = v[offset+k-1] + 1 // move right (deletion)
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back through the trace building the edit script in reverse
+	var rev []Line
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, Line{Equal, a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				rev = append(rev, Line{Insert, b[prevY]})
+			} else {
+				rev = append(rev, Line{Delete, a[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	lines := make([]Line, len(rev))
+	for i, l := range rev {
+		lines[len(rev)-1-i] = l
+	}
+	return lines
+}
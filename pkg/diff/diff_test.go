@@ -0,0 +1,97 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []Line
+	}{
+		{
+			name: "Identical",
+			a:    "one\ntwo\n",
+			b:    "one\ntwo",
+			want: []Line{{Equal, "one"}, {Equal, "two"}},
+		}, {
+			name: "Both empty",
+			a:    "",
+			b:    "",
+			want: nil,
+		}, {
+			name: "From empty",
+			a:    "",
+			b:    "one",
+			want: []Line{{Insert, "one"}},
+		}, {
+			name: "To empty",
+			a:    "one",
+			b:    "",
+			want: []Line{{Delete, "one"}},
+		}, {
+			name: "Changed middle line",
+			a:    "one\ntwo\nthree",
+			b:    "one\n2\nthree",
+			want: []Line{{Equal, "one"}, {Delete, "two"}, {Insert, "2"}, {Equal, "three"}},
+		}, {
+			name: "CRLF line endings",
+			a:    "one\r\ntwo\r\n",
+			b:    "one\ntwo\n",
+			want: []Line{{Equal, "one"}, {Equal, "two"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compare(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnified(t *testing.T) {
+
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+	want := `--- rev1
++++ rev2
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+`
+
+	if got := Unified("rev1", "rev2", a, b); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+
+	if got := Unified("rev1", "rev2", a, a); got != "" {
+		t.Errorf("want no diff for identical texts; got\n%s", got)
+	}
+}
+
+func TestHunksEmptyRange(t *testing.T) {
+
+	hunks := Hunks("", "one\ntwo", 3)
+	if len(hunks) != 1 {
+		t.Fatalf("want 1 hunk; got %d", len(hunks))
+	}
+	if h := hunks[0].Header(); h != "@@ -0,0 +1,2 @@" {
+		t.Errorf("want %q; got %q", "@@ -0,0 +1,2 @@", h)
+	}
+}
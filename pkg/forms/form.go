@@ -2,17 +2,21 @@ package forms
 
 import (
 	"fmt"
+	"mime/multipart"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
 // Form struct anonymously embeds a url.Values object
 // (to hold the form data) and an Errors field to hold any validation errors
-// for the form data.
+// for the form data.  For multipart forms Files holds the uploaded files.
 type Form struct {
 	url.Values
+	Files  map[string][]*multipart.FileHeader
 	Errors errors
 }
 
@@ -20,9 +24,18 @@ type Form struct {
 // c.f. https://emailregex.com/
 var EmailRX = regexp.MustCompile("(?:[a-z0-9!#$%&'*+/=?^_`{|}~-]+(?:\\.[a-z0-9!#$%&'*+/=?^_`{|}~-]+)*|\"(?:[\x01-\x08\x0b\x0c\x0e-\x1f\x21\x23-\x5b\x5d-\x7f]|\\[\x01-\x09\x0b\x0c\x0e-\x7f])*\")@(?:(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\\.)+[a-z0-9](?:[a-z0-9-]*[a-z0-9])?|\\[(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?|[a-z0-9-]*[a-z0-9]:(?:[\x01-\x08\x0b\x0c\x0e-\x1f\x21-\x5a\x53-\x7f]|\\[\x01-\x09\x0b\x0c\x0e-\x7f])+)\\])")
 
+// TagRX matches a single tag: a letter or digit followed by up to 29 letters,
+// digits, dots, dashes or underscores
+var TagRX = regexp.MustCompile(`^(?i)[a-z0-9][a-z0-9._-]{0,29}$`)
+
+// FilenameRX matches a file name without any directories: a letter, digit or
+// underscore followed by up to 99 letters, digits, dots, dashes or
+// underscores
+var FilenameRX = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,99}$`)
+
 // New initializes a custom Form struct.  Form data is passed as a parameter
 func New(data url.Values) *Form {
-	return &Form{data, errors(map[string][]string{})}
+	return &Form{Values: data, Errors: errors(map[string][]string{})}
 }
 
 // Required checks that specific fields in the form
@@ -63,6 +76,15 @@ func (f *Form) MaxLength(field string, d int) {
 	}
 }
 
+// MaxSize checks that a specific field in the form contains no more than a
+// maximum number of bytes.  If the check fails then add the appropriate
+// message to the form errors.
+func (f *Form) MaxSize(field string, d int64) {
+	if int64(len(f.Get(field))) > d {
+		f.Errors.Add(field, fmt.Sprintf("This field is too large (maximum is %s)", FormatSize(d)))
+	}
+}
+
 // PermittedValues checks that a specific field in the form
 // matches one of a set of specific permitted values. If the check fails
 // then add the appropriate message to the form errors.
@@ -92,6 +114,190 @@ func (f *Form) MatchesPattern(field string, pattern *regexp.Regexp) {
 	}
 }
 
+// PositiveInteger checks that a specific field in the form is a whole number
+// greater than zero.  If the check fails then add the appropriate message to
+// the form errors.
+func (f *Form) PositiveInteger(field string) {
+	value := f.Get(field)
+	if value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		f.Errors.Add(field, "This field must be a positive whole number")
+	}
+}
+
+// MaxValue checks that a specific integer field in the form is no greater
+// than a maximum.  If the check fails then add the appropriate message to
+// the form errors.
+func (f *Form) MaxValue(field string, d int) {
+	value := f.Get(field)
+	if value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err == nil && n > d {
+		f.Errors.Add(field, fmt.Sprintf("This field must be no more than %d", d))
+	}
+}
+
+// ValidDate checks that a specific field in the form is a date or time in
+// the given layout (c.f. time.Parse).  If the check fails then add the
+// appropriate message to the form errors.
+func (f *Form) ValidDate(field, layout string) {
+	value := f.Get(field)
+	if value == "" {
+		return
+	}
+	if _, err := time.Parse(layout, value); err != nil {
+		f.Errors.Add(field, "This field is not a valid date")
+	}
+}
+
+// GetList splits a specific comma-separated field in the form into its
+// items.  Surrounding whitespace is trimmed and empty or repeated items are
+// dropped.
+func (f *Form) GetList(field string) []string {
+	items := []string{}
+	seen := map[string]bool{}
+	for _, item := range strings.Split(f.Get(field), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		items = append(items, item)
+	}
+	return items
+}
+
+// MaxItems checks that a specific comma-separated field in the form contains
+// no more than a maximum number of items.  If the check fails then add the
+// appropriate message to the form errors.
+func (f *Form) MaxItems(field string, d int) {
+	if len(f.GetList(field)) > d {
+		f.Errors.Add(field, fmt.Sprintf("This field has too many entries (maximum is %d)", d))
+	}
+}
+
+// ItemsMatchPattern checks that every item of a specific comma-separated
+// field in the form matches a regular expression pattern.  If the check fails
+// then add the appropriate message to the form errors.
+func (f *Form) ItemsMatchPattern(field string, pattern *regexp.Regexp) {
+	for _, item := range f.GetList(field) {
+		if !pattern.MatchString(item) {
+			f.Errors.Add(field, "This field contains an invalid entry")
+			return
+		}
+	}
+}
+
+// Entries returns every value submitted for a specific repeated field in the
+// form, e.g. one for each of a set of inputs sharing a name, in order.
+// Validation errors for the value at index i are recorded under the key
+// "field.i" (c.f. EntryKey).
+func (f *Form) Entries(field string) []string {
+	return f.Values[field]
+}
+
+// EntryKey returns the key under which validation errors for the value at
+// a specific index of a repeated field are recorded
+func EntryKey(field string, i int) string {
+	return field + "." + strconv.Itoa(i)
+}
+
+// RequiredEntries checks that a specific repeated field in the form has at
+// least one value and that none of its values are blank.  If the check fails
+// then add the appropriate message to the form errors.
+func (f *Form) RequiredEntries(field string) {
+	entries := f.Entries(field)
+	if len(entries) == 0 {
+		f.Errors.Add(EntryKey(field, 0), "This field cannot be blank")
+	}
+	for i, value := range entries {
+		if strings.TrimSpace(value) == "" {
+			f.Errors.Add(EntryKey(field, i), "This field cannot be blank")
+		}
+	}
+}
+
+// MaxLengthEntries checks that every value of a specific repeated field in
+// the form contains a maximum number of characters.  If the check fails then
+// add the appropriate message to the form errors.
+func (f *Form) MaxLengthEntries(field string, d int) {
+	for i, value := range f.Entries(field) {
+		if utf8.RuneCountInString(value) > d {
+			f.Errors.Add(EntryKey(field, i), fmt.Sprintf("This field is too long (maximum is %d characters)", d))
+		}
+	}
+}
+
+// MaxSizeEntries checks that every value of a specific repeated field in the
+// form contains no more than a maximum number of bytes.  If the check fails
+// then add the appropriate message to the form errors.
+func (f *Form) MaxSizeEntries(field string, d int64) {
+	for i, value := range f.Entries(field) {
+		if int64(len(value)) > d {
+			f.Errors.Add(EntryKey(field, i), fmt.Sprintf("This field is too large (maximum is %s)", FormatSize(d)))
+		}
+	}
+}
+
+// PermittedEntries checks that every non-blank value of a specific repeated
+// field in the form matches one of a set of specific permitted values.  If
+// the check fails then add the appropriate message to the form errors.
+func (f *Form) PermittedEntries(field string, opts ...string) {
+entries:
+	for i, value := range f.Entries(field) {
+		if value == "" {
+			continue
+		}
+		for _, opt := range opts {
+			if value == opt {
+				continue entries
+			}
+		}
+		f.Errors.Add(EntryKey(field, i), "This field is invalid")
+	}
+}
+
+// EntriesMatchPattern checks that every non-blank value of a specific
+// repeated field in the form matches a regular expression pattern.  If the
+// check fails then add the appropriate message to the form errors.
+func (f *Form) EntriesMatchPattern(field string, pattern *regexp.Regexp) {
+	for i, value := range f.Entries(field) {
+		if value != "" && !pattern.MatchString(value) {
+			f.Errors.Add(EntryKey(field, i), "This field is invalid")
+		}
+	}
+}
+
+// UniqueEntries checks that no non-blank value of a specific repeated field
+// in the form is repeated.  If the check fails then add the appropriate
+// message to the form errors.
+func (f *Form) UniqueEntries(field string) {
+	seen := map[string]bool{}
+	for i, value := range f.Entries(field) {
+		if value == "" {
+			continue
+		}
+		if seen[value] {
+			f.Errors.Add(EntryKey(field, i), "This field must not repeat an earlier entry")
+		}
+		seen[value] = true
+	}
+}
+
+// MaxEntries checks that a specific repeated field in the form has no more
+// than a maximum number of values.  If the check fails then add the
+// appropriate message to the form errors.
+func (f *Form) MaxEntries(field string, d int) {
+	if len(f.Entries(field)) > d {
+		f.Errors.Add(field, fmt.Sprintf("This field has too many entries (maximum is %d)", d))
+	}
+}
+
 // Valid method which returns true if there are no errors
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
@@ -0,0 +1,83 @@
+package forms
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// FileType detects the content type of an uploaded file from its first bytes
+// (c.f. http.DetectContentType) rather than trusting the type sent with it
+func FileType(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// MaxFiles checks that no more than a maximum number of files were uploaded
+// in a specific field of the form.  If the check fails then add the
+// appropriate message to the form errors.
+func (f *Form) MaxFiles(field string, d int) {
+	if len(f.Files[field]) > d {
+		f.Errors.Add(field, fmt.Sprintf("This field has too many files (maximum is %d)", d))
+	}
+}
+
+// MaxFileSize checks that every file uploaded in a specific field of the form
+// is no larger than a maximum number of bytes.  If the check fails then add
+// the appropriate message to the form errors.
+func (f *Form) MaxFileSize(field string, d int64) {
+	for _, fh := range f.Files[field] {
+		if fh.Size > d {
+			f.Errors.Add(field, fmt.Sprintf("%s is too large (maximum is %s)", fh.Filename, FormatSize(d)))
+		}
+	}
+}
+
+// PermittedFileTypes checks that the content of every file uploaded in a
+// specific field of the form is one of a set of permitted media types, e.g.
+// "image/png", ignoring parameters such as charset.  If the check fails then
+// add the appropriate message to the form errors.
+func (f *Form) PermittedFileTypes(field string, types ...string) {
+files:
+	for _, fh := range f.Files[field] {
+		contentType, err := FileType(fh)
+		if err != nil {
+			f.Errors.Add(field, fmt.Sprintf("%s could not be read", fh.Filename))
+			continue
+		}
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		for _, t := range types {
+			if mediaType == t {
+				continue files
+			}
+		}
+		f.Errors.Add(field, fmt.Sprintf("%s is not a permitted type of file", fh.Filename))
+	}
+}
+
+// FormatSize returns a number of bytes in human-friendly units, e.g. "1.5 MB"
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", value), ".0") + " " + string("KMGT"[exp]) + "B"
+}
@@ -0,0 +1,386 @@
+// Package highlight provides a small regular expression based syntax
+// highlighter.  Source code is rendered as HTML-escaped text in which each
+// recognized token is wrapped in a <span> carrying a CSS class, so the result
+// can be styled without any client-side JavaScript.
+//
+// Classes used are: hl-kw (keyword), hl-typ (type or builtin), hl-lit
+// (literal such as true or null), hl-str (string), hl-num (number), hl-com
+// (comment), hl-var (variable), hl-key (object or mapping key), hl-ins and
+// hl-del (inserted and deleted lines), and hl-meta (headers and directives).
+
+package highlight
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Language describes a language supported by the highlighter
+type Language struct {
+	Name      string
+	Label     string
+	Extension string // of files written in the language
+}
+
+// Text is the name of the plain text language which applies no highlighting
+const Text = "text"
+
+// Languages lists the supported languages in the order they should be
+// offered to users
+var Languages = []Language{
+	{Text, "Plain text", ".txt"},
+	{"go", "Go", ".go"},
+	{"sql", "SQL", ".sql"},
+	{"shell", "Shell", ".sh"},
+	{"json", "JSON", ".json"},
+	{"yaml", "YAML", ".yaml"},
+	{"diff", "Diff", ".diff"},
+	{"markdown", "Markdown", ".md"}, // rendered rather than highlighted
+}
+
+// Names returns the names of the supported languages
+func Names() []string {
+	names := make([]string, len(Languages))
+	for i, l := range Languages {
+		names[i] = l.Name
+	}
+	return names
+}
+
+// Label returns the human-friendly name of a language, or that of plain text
+// for unsupported languages
+func Label(name string) string {
+	for _, l := range Languages {
+		if l.Name == name {
+			return l.Label
+		}
+	}
+	return Languages[0].Label
+}
+
+// Extension returns the file name extension for a language, or that of plain
+// text for unsupported languages
+func Extension(name string) string {
+	for _, l := range Languages {
+		if l.Name == name {
+			return l.Extension
+		}
+	}
+	return Languages[0].Extension
+}
+
+// rule recognizes one kind of token.  The token is given the first class, or
+// when the pattern has capture groups, each group is given the class at the
+// same position.  Groups must be consecutive and cover the whole match.
+type rule struct {
+	pattern *regexp.Regexp
+	scan    func(string) int // recognize the token by hand instead
+	classes []string
+	bol     bool              // only match at the beginning of a line
+	words   map[string]string // classify the match by looking it up instead
+	fold    bool              // look words up case-insensitively
+}
+
+// lexer is an ordered list of rules; the first rule to match wins
+type lexer []rule
+
+// newRule compiles a rule anchored to the current position
+func newRule(pattern string, classes ...string) rule {
+	return rule{pattern: regexp.MustCompile(`^(?:` + pattern + `)`), classes: classes}
+}
+
+// lineRule compiles a rule which only matches at the start of a line
+func lineRule(pattern string, classes ...string) rule {
+	r := newRule(pattern, classes...)
+	r.bol = true
+	return r
+}
+
+// scanRule makes a rule recognizing tokens with a scanner, which returns the
+// length of the token at the start of its input or 0 if there is none there.
+// Scanners are used for tokens such as strings which may be left unclosed, so
+// that every token runs to its end or the end of the input at the most and
+// is then passed over.  A pattern would instead look for the end of an
+// unclosed token again at every position after its start.
+func scanRule(scan func(string) int, class string) rule {
+	return rule{scan: scan, classes: []string{class}}
+}
+
+// wordRule compiles a rule which classifies identifiers using a lookup table
+func wordRule(pattern string, fold bool, words map[string]string) rule {
+	r := newRule(pattern)
+	r.words = words
+	r.fold = fold
+	return r
+}
+
+// classify builds a word lookup table giving every word in each
+// space-separated list the class it is keyed by
+func classify(lists map[string]string) map[string]string {
+	words := map[string]string{}
+	for class, list := range lists {
+		for _, w := range strings.Fields(list) {
+			words[w] = class
+		}
+	}
+	return words
+}
+
+// quoted returns a scanner for strings between quote characters, in which a
+// backslash escapes the next character if escapes is set.  A string left
+// unclosed runs to the end of its line, or of the input if multiline.
+func quoted(quote byte, escapes, multiline bool) func(string) int {
+	return func(s string) int {
+		if s == "" || s[0] != quote {
+			return 0
+		}
+		for i := 1; i < len(s); i++ {
+			switch {
+			case s[i] == quote:
+				return i + 1
+			case s[i] == '\n' && !multiline:
+				return i
+			case s[i] == '\\' && escapes && i+1 < len(s):
+				if s[i+1] == '\n' && !multiline {
+					return i + 1
+				}
+				i++
+			}
+		}
+		return len(s)
+	}
+}
+
+// blockComment scans a comment from /* to */, or to the end of the input if
+// it is left unclosed
+func blockComment(s string) int {
+	if !strings.HasPrefix(s, "/*") {
+		return 0
+	}
+	if i := strings.Index(s[2:], "*/"); i >= 0 {
+		return i + len("/**/")
+	}
+	return len(s)
+}
+
+// jsonKey scans a string followed by a colon, which names a member of an
+// object
+func jsonKey(s string) int {
+	n := doubleQuotedString(s)
+	if n == 0 || !strings.HasPrefix(strings.TrimLeft(s[n:], " \t\r\n"), ":") {
+		return 0
+	}
+	return n
+}
+
+// Shared token scanners
+var (
+	doubleQuotedString = quoted('"', true, false)
+	singleQuotedString = quoted('\'', true, false)
+)
+
+// Shared token patterns
+const (
+	whitespace   = `\s+`
+	doubleQuoted = `"(?:[^"\\\n]|\\.)*"`
+	singleQuoted = `'(?:[^'\\\n]|\\.)*'`
+	number       = `0[xX][0-9a-fA-F_]+|\d[\d_]*(?:\.\d+)?(?:[eE][+-]?\d+)?`
+	signedNumber = `-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?`
+)
+
+var lexers = map[string]lexer{
+	"go": {
+		newRule(whitespace),
+		newRule(`//[^\n]*`, "hl-com"),
+		scanRule(blockComment, "hl-com"),
+		scanRule(doubleQuotedString, "hl-str"),
+		newRule("`[^`]*`?", "hl-str"),
+		scanRule(singleQuotedString, "hl-str"),
+		wordRule(`[A-Za-z_]\w*`, false, classify(map[string]string{
+			"hl-kw": "break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var",
+			"hl-typ": "bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr " +
+				"append cap close complex copy delete imag len make new panic print println real recover",
+			"hl-lit": "true false nil iota",
+		})),
+		newRule(number, "hl-num"),
+	},
+	"sql": {
+		newRule(whitespace),
+		newRule(`--[^\n]*|#[^\n]*`, "hl-com"),
+		scanRule(blockComment, "hl-com"),
+		scanRule(singleQuotedString, "hl-str"),
+		scanRule(doubleQuotedString, "hl-str"),
+		scanRule(quoted('`', false, false), "hl-str"),
+		wordRule(`[A-Za-z_]\w*`, true, classify(map[string]string{
+			"hl-kw": "add all alter and as asc begin between by case check column commit constraint create cross database default delete desc distinct drop else end exists " +
+				"foreign from full group having if in index inner insert interval into is join key left like limit not null offset on or order outer primary " +
+				"references replace right rollback select set table then transaction truncate union unique update using values view when where with",
+			"hl-typ": "bigint binary bit blob boolean char date datetime decimal double enum float int integer json longtext mediumtext numeric real smallint text time timestamp tinyint varbinary varchar " +
+				"avg coalesce concat count date_add date_sub ifnull lower max min now sum upper utc_timestamp",
+			"hl-lit": "true false",
+		})),
+		newRule(number, "hl-num"),
+	},
+	"shell": {
+		newRule(whitespace),
+		newRule(`#[^\n]*`, "hl-com"),
+		scanRule(quoted('"', true, true), "hl-str"),
+		scanRule(quoted('\'', false, true), "hl-str"),
+		newRule(`\$\{[^}\n]*\}?|\$\w+|\$[@#?$!*-]`, "hl-var"),
+		wordRule(`[A-Za-z_][\w-]*`, false, classify(map[string]string{
+			"hl-kw":  "case do done elif else esac fi for function if in select then time until while return exit break continue",
+			"hl-typ": "alias cd declare echo eval exec export local printf read readonly set shift source test trap unset",
+		})),
+		newRule(`\d+`, "hl-num"),
+	},
+	"json": {
+		newRule(whitespace),
+		scanRule(jsonKey, "hl-key"),
+		scanRule(doubleQuotedString, "hl-str"),
+		newRule(`true|false|null`, "hl-lit"),
+		newRule(signedNumber, "hl-num"),
+	},
+	"yaml": {
+		lineRule(`---|\.\.\.`, "hl-meta"),
+		newRule(`#[^\n]*`, "hl-com"),
+		lineRule(`([ \t]*(?:-[ \t]+)*)([\w./-][\w ./-]*?|`+doubleQuoted+`|`+singleQuoted+`)([ \t]*:)(\s|$)`, "", "hl-key", "", ""),
+		newRule(`[ \t]+|\n`), // stop at line ends so keys can be found
+		scanRule(doubleQuotedString, "hl-str"),
+		scanRule(singleQuotedString, "hl-str"),
+		newRule(`[&*][\w-]+`, "hl-var"),
+		newRule(`![\w!/.-]*`, "hl-typ"),
+		wordRule(`[A-Za-z_][\w.-]*`, false, classify(map[string]string{
+			"hl-lit": "true false yes no on off null True False Yes No On Off Null TRUE FALSE NULL",
+		})),
+		newRule(signedNumber, "hl-num"),
+	},
+	"diff": {
+		lineRule(`(?:---|\+\+\+|diff |index )[^\n]*`, "hl-meta"),
+		lineRule(`@@[^\n]*`, "hl-meta"),
+		lineRule(`\+[^\n]*`, "hl-ins"),
+		lineRule(`-[^\n]*`, "hl-del"),
+		newRule(`[^\n]*\n?`),
+	},
+}
+
+// HTML returns the source code escaped for inclusion in an HTML document
+// with its tokens marked up according to the language.  Unsupported
+// languages are simply escaped.
+func HTML(language, src string) string {
+	lex, ok := lexers[language]
+	if !ok {
+		return html.EscapeString(src)
+	}
+
+	var out, plain strings.Builder
+
+	// emit writes a token, batching up unclassified text
+	emit := func(class, text string) {
+		if text == "" {
+			return
+		}
+		if class == "" {
+			plain.WriteString(text)
+			return
+		}
+		out.WriteString(html.EscapeString(plain.String()))
+		plain.Reset()
+		out.WriteString("<span class='" + class + "'>")
+		out.WriteString(html.EscapeString(text))
+		out.WriteString("</span>")
+	}
+
+	pos := 0
+	for pos < len(src) {
+		rest := src[pos:]
+		bol := pos == 0 || src[pos-1] == '\n'
+		matched := false
+
+		for _, r := range lex {
+			if r.bol && !bol {
+				continue
+			}
+			var m []string
+			if r.scan != nil {
+				if n := r.scan(rest); n > 0 {
+					m = []string{rest[:n]}
+				}
+			} else {
+				m = r.pattern.FindStringSubmatch(rest)
+			}
+			if m == nil || m[0] == "" {
+				continue
+			}
+			switch {
+			case r.words != nil:
+				word := m[0]
+				if r.fold {
+					word = strings.ToLower(word)
+				}
+				emit(r.words[word], m[0])
+			case len(m) > 1:
+				for i, group := range m[1:] {
+					emit(r.classes[i], group)
+				}
+			case len(r.classes) > 0:
+				emit(r.classes[0], m[0])
+			default:
+				emit("", m[0])
+			}
+			pos += len(m[0])
+			matched = true
+			break
+		}
+
+		// Characters no rule recognizes are passed through as they are
+		if !matched {
+			_, size := utf8.DecodeRuneInString(rest)
+			emit("", rest[:size])
+			pos += size
+		}
+	}
+	out.WriteString(html.EscapeString(plain.String()))
+
+	return out.String()
+}
+
+// Lines returns the source code marked up as by HTML split into lines, so
+// that each may be shown apart from the others.  A token spanning several
+// lines is closed at the end of each and reopened at the start of the next.
+// Windows line endings are accepted, and a final line break does not begin
+// another line.
+func Lines(language, src string) []string {
+	src = strings.TrimSuffix(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	marked := HTML(language, src)
+
+	var lines []string
+	var line strings.Builder
+	open := "" // the opening tag of the token being written, if any
+	for i := 0; i < len(marked); {
+		rest := marked[i:]
+		switch {
+		case strings.HasPrefix(rest, "<span "):
+			open = rest[:strings.IndexByte(rest, '>')+1]
+			line.WriteString(open)
+			i += len(open)
+		case strings.HasPrefix(rest, "</span>"):
+			open = ""
+			line.WriteString("</span>")
+			i += len("</span>")
+		case rest[0] == '\n':
+			if open != "" {
+				line.WriteString("</span>")
+			}
+			lines = append(lines, line.String())
+			line.Reset()
+			line.WriteString(open)
+			i++
+		default:
+			line.WriteByte(rest[0])
+			i++
+		}
+	}
+	return append(lines, line.String())
+}
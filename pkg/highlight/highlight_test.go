@@ -0,0 +1,159 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTML(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		language string
+		src      string
+		want     string
+	}{
+		{
+			name:     "Plain text is escaped",
+			language: Text,
+			src:      "<script>alert(1)</script>",
+			want:     "&lt;script&gt;alert(1)&lt;/script&gt;",
+		}, {
+			name:     "Unknown language is escaped",
+			language: "cobol",
+			src:      "a < b",
+			want:     "a &lt; b",
+		}, {
+			name:     "Go",
+			language: "go",
+			src:      "func main() { // hi\n\tx := \"<a>\" + 42\n}",
+			want:     "<span class='hl-kw'>func</span> main() { <span class='hl-com'>// hi</span>\n\tx := <span class='hl-str'>&#34;&lt;a&gt;&#34;</span> + <span class='hl-num'>42</span>\n}",
+		}, {
+			name:     "Go keywords inside identifiers",
+			language: "go",
+			src:      "iffy forward",
+			want:     "iffy forward",
+		}, {
+			name:     "SQL keywords are case-insensitive",
+			language: "sql",
+			src:      "SELECT id FROM t -- all",
+			want:     "<span class='hl-kw'>SELECT</span> id <span class='hl-kw'>FROM</span> t <span class='hl-com'>-- all</span>",
+		}, {
+			name:     "Shell",
+			language: "shell",
+			src:      "echo \"$HOME\" $USER # who",
+			want:     "<span class='hl-typ'>echo</span> <span class='hl-str'>&#34;$HOME&#34;</span> <span class='hl-var'>$USER</span> <span class='hl-com'># who</span>",
+		}, {
+			name:     "JSON",
+			language: "json",
+			src:      `{"a": [1, -2.5, true, "x"]}`,
+			want:     "{<span class='hl-key'>&#34;a&#34;</span>: [<span class='hl-num'>1</span>, <span class='hl-num'>-2.5</span>, <span class='hl-lit'>true</span>, <span class='hl-str'>&#34;x&#34;</span>]}",
+		}, {
+			name:     "YAML",
+			language: "yaml",
+			src:      "---\nname: web # app\n  - port: 80\nurl: http://x\n",
+			want:     "<span class='hl-meta'>---</span>\n<span class='hl-key'>name</span>: web <span class='hl-com'># app</span>\n  - <span class='hl-key'>port</span>: <span class='hl-num'>80</span>\n<span class='hl-key'>url</span>: http://x\n",
+		}, {
+			name:     "Unclosed strings run to the end of the line",
+			language: "go",
+			src:      "x := \"a\\\"b\ny := 'c",
+			want:     "x := <span class='hl-str'>&#34;a\\&#34;b</span>\ny := <span class='hl-str'>&#39;c</span>",
+		}, {
+			name:     "Unclosed comments run to the end of the input",
+			language: "sql",
+			src:      "a /* b\nc",
+			want:     "a <span class='hl-com'>/* b\nc</span>",
+		}, {
+			name:     "Diff",
+			language: "diff",
+			src:      "--- a\n+++ b\n@@ -1 +1 @@\n-old\n+new\n same",
+			want:     "<span class='hl-meta'>--- a</span>\n<span class='hl-meta'>+++ b</span>\n<span class='hl-meta'>@@ -1 +1 @@</span>\n<span class='hl-del'>-old</span>\n<span class='hl-ins'>+new</span>\n same",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTML(tt.language, tt.src); got != tt.want {
+				t.Errorf("want\n%q\ngot\n%q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHTMLUnclosed(t *testing.T) {
+
+	// Tokens left unclosed must not be looked for again from every position
+	// after their start, which takes time growing with the square of the
+	// input's length: long enough to stall a request on a snippet of this
+	// size
+	tests := []struct {
+		name     string
+		language string
+		src      string
+	}{
+		{"Go string", "go", strings.Repeat(`"\`, 100000)},
+		{"Go rune", "go", strings.Repeat(`'\`, 100000)},
+		{"SQL string", "sql", strings.Repeat(`'\`, 100000)},
+		{"Shell string", "shell", strings.Repeat(`"\`, 100000)},
+		{"Shell variable", "shell", strings.Repeat("${", 100000)},
+		{"JSON string", "json", strings.Repeat(`"\`, 100000)},
+		{"YAML string", "yaml", strings.Repeat(`'\`, 100000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			Lines(tt.language, tt.src)
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("want highlighting %d bytes to take under 2s; took %s", len(tt.src), d)
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		language string
+		src      string
+		want     []string
+	}{
+		{
+			name:     "Empty",
+			language: Text,
+			src:      "",
+			want:     []string{""},
+		}, {
+			name:     "Final line break",
+			language: Text,
+			src:      "a <\r\nb\r\n",
+			want:     []string{"a &lt;", "b"},
+		}, {
+			name:     "Blank lines",
+			language: Text,
+			src:      "a\n\nb",
+			want:     []string{"a", "", "b"},
+		}, {
+			name:     "Token spanning lines",
+			language: "go",
+			src:      "x /* one\ntwo */ 1",
+			want:     []string{"x <span class='hl-com'>/* one</span>", "<span class='hl-com'>two */</span> <span class='hl-num'>1</span>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Lines(tt.language, tt.src)
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %q; got %q", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("want line %d %q; got %q", i+1, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
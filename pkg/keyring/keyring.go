@@ -0,0 +1,229 @@
+// Package keyring encrypts data at rest by envelope encryption.  Each piece of
+// data is encrypted with AES-GCM under its own random data key, which is kept
+// alongside it encrypted ("wrapped") under one of the long-lived master keys
+// of a keyring.  Rotating master keys then only needs the small data keys
+// rewrapping rather than the data itself re-encrypting.
+//
+// A keyring also has a hash key, with which data can be identified without
+// its identifier revealing it to anyone able to guess it, as a plain hash
+// would.  It is never rotated, as doing so would change every identifier.
+
+package keyring
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Errors returned by keyrings
+var (
+	ErrUnknownKey = errors.New("keyring: unknown key")
+	ErrDecrypt    = errors.New("keyring: message authentication failed")
+)
+
+// keySize is the size in bytes of both master and data keys, for AES-256
+const keySize = 32
+
+// idRX matches the IDs of master keys, which are recorded with everything
+// encrypted under them
+var idRX = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+// hashKeyID is the ID reserved for the hash key in keyring files
+const hashKeyID = "hash"
+
+// Keyring holds a set of master keys by ID, one of which is current and used
+// to wrap the data keys of everything newly encrypted.  The others are kept
+// to unwrap data keys wrapped under them before the last rotation.
+type Keyring struct {
+	keys    map[string]cipher.AEAD
+	current string
+	hashKey []byte
+}
+
+// Load reads a keyring from a file as described by Parse
+func Load(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads a keyring with a key on each line: its ID and the base64
+// encoding of its 32 bytes, separated by white space.  The key with the ID
+// "hash" is the hash key and every other is a master key.  The last master
+// key is the current one, so a key is rotated in by appending it.  Blank
+// lines and lines starting with '#' are ignored.
+//
+//	# Snippet content keys
+//	hash    Gx2Rk0c4vH7pQ1sT9wY3zB6nE8mJ5aL0dF2gK4hU7iO=
+//	2020-01 q83vEjRWeJq83vEjRWeJq83vEjRWeJq83vEjRWeJq80=
+//	2020-07 h5S2K1mUuVX8qQ3n0Yx0fZ9N4m7Q6m8Lw1s9cVbR2pE=
+func Parse(r io.Reader) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !idRX.MatchString(fields[0]) {
+			return nil, fmt.Errorf("keyring: line %d: want a key ID and a base64-encoded key", n)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("keyring: line %d: want a base64-encoded %d-byte key", n, keySize)
+		}
+		if fields[0] == hashKeyID {
+			if k.hashKey != nil {
+				return nil, fmt.Errorf("keyring: line %d: duplicate hash key", n)
+			}
+			k.hashKey = key
+			continue
+		}
+		if err = k.add(fields[0], key); err != nil {
+			return nil, fmt.Errorf("keyring: line %d: %w", n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if k.current == "" {
+		return nil, errors.New("keyring: no master keys")
+	}
+	if k.hashKey == nil {
+		return nil, errors.New("keyring: no hash key")
+	}
+	return k, nil
+}
+
+// add puts a master key into the keyring, making it current
+func (k *Keyring) add(id string, key []byte) error {
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key ID %q", id)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	k.keys[id] = aead
+	k.current = id
+	return nil
+}
+
+// Current returns the ID of the master key wrapping new data keys
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// Hash returns an HMAC-SHA256 of data under the hash key, which identifies
+// it like a hash but cannot be computed without the keyring
+func (k *Keyring) Hash(data []byte) []byte {
+	mac := hmac.New(sha256.New, k.hashKey)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Seal encrypts plaintext under a new data key, returning the ID of the
+// master key it is wrapped under, the wrapped data key and the ciphertext.
+// The additional data is authenticated but not encrypted, binding the
+// ciphertext to it; the same must be given to Open.
+func (k *Keyring) Seal(plaintext, additional []byte) (keyID string, dataKey, ciphertext []byte, err error) {
+	key := make([]byte, keySize)
+	if _, err = rand.Read(key); err != nil {
+		return "", nil, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if ciphertext, err = seal(aead, plaintext, additional); err != nil {
+		return "", nil, nil, err
+	}
+	if dataKey, err = seal(k.keys[k.current], key, []byte(k.current)); err != nil {
+		return "", nil, nil, err
+	}
+	return k.current, dataKey, ciphertext, nil
+}
+
+// Open decrypts ciphertext returned by Seal given the ID of the master key
+// and the wrapped data key returned with it
+func (k *Keyring) Open(keyID string, dataKey, ciphertext, additional []byte) ([]byte, error) {
+	key, err := k.unwrap(keyID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, ciphertext, additional)
+}
+
+// Rewrap returns a data key wrapped under the given master key rewrapped
+// under the current one, along with the current key's ID.  Whatever was
+// encrypted under the data key is unchanged.
+func (k *Keyring) Rewrap(keyID string, dataKey []byte) (string, []byte, error) {
+	key, err := k.unwrap(keyID, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if dataKey, err = seal(k.keys[k.current], key, []byte(k.current)); err != nil {
+		return "", nil, err
+	}
+	return k.current, dataKey, nil
+}
+
+// unwrap returns a data key wrapped under the given master key
+func (k *Keyring) unwrap(keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	return open(aead, dataKey, []byte(keyID))
+}
+
+// newAEAD returns AES-GCM under a key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under a random nonce, which is prepended to the
+// result
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open decrypts the result of seal
+func open(aead cipher.AEAD, ciphertext, additional []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additional)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
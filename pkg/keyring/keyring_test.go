@@ -0,0 +1,170 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	hashKey = "hash QEFCQ0RFRkdISUpLTE1OT1BRUlNUVVZXWFlaW1xdXl8=\n"
+	oldKeys = "# Test keys\n" + hashKey +
+		"2020-01 AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n"
+	newKeys = oldKeys +
+		"\n" +
+		"2020-07 ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8=\n"
+)
+
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		keys        string
+		wantCurrent string
+	}{
+		{"One key", oldKeys, "2020-01"},
+		{"Rotated", newKeys, "2020-07"},
+		{"Empty", "# No keys yet\n", ""},
+		{"Only a hash key", hashKey, ""},
+		{"No hash key", "2020-01 AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n", ""},
+		{"Missing key", hashKey + "2020-01\n", ""},
+		{"Short key", hashKey + "2020-01 AAECAwQFBgcICQoLDA0ODw==\n", ""},
+		{"Not base64", hashKey + "2020-01 not-a-key\n", ""},
+		{"Bad ID", hashKey + "2020/01 AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n", ""},
+		{"Duplicate ID", oldKeys + "2020-01 AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n", ""},
+		{"Duplicate hash key", oldKeys + hashKey, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := Parse(strings.NewReader(tt.keys))
+			if tt.wantCurrent == "" {
+				if err == nil {
+					t.Errorf("want error; got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.Current() != tt.wantCurrent {
+				t.Errorf("want current key %q; got %q", tt.wantCurrent, k.Current())
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+
+	old, err := Parse(strings.NewReader(oldKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := Parse(strings.NewReader(newKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Parse(strings.NewReader(strings.Replace(newKeys, "QEFC", "QUFC", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("correct horse battery staple")
+	plain := sha256.Sum256(data)
+	h := old.Hash(data)
+	if len(h) != sha256.Size || bytes.Equal(h, plain[:]) {
+		t.Errorf("want a keyed hash unlike the plain hash")
+	}
+
+	// Rotating master keys leaves hashes alone, but another hash key changes
+	// them
+	if !bytes.Equal(rotated.Hash(data), h) {
+		t.Errorf("want the same hash after rotation")
+	}
+	if bytes.Equal(other.Hash(data), h) {
+		t.Errorf("want a different hash under another hash key")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+
+	old, err := Parse(strings.NewReader(oldKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := Parse(strings.NewReader(newKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("An old silent pond...")
+	additional := []byte("pond")
+	keyID, dataKey, ciphertext, err := old.Seal(plaintext, additional)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "2020-01" {
+		t.Errorf("want key ID %q; got %q", "2020-01", keyID)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Errorf("want ciphertext not to contain the plaintext")
+	}
+
+	// Sealing the same plaintext again uses a new data key
+	_, dataKey2, ciphertext2, err := old.Seal(plaintext, additional)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(dataKey, dataKey2) || bytes.Equal(ciphertext, ciphertext2) {
+		t.Errorf("want each seal to differ")
+	}
+
+	// The rotated keyring still opens what was sealed before the rotation
+	for _, k := range []*Keyring{old, rotated} {
+		got, err := k.Open(keyID, dataKey, ciphertext, additional)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("want %q; got %q", plaintext, got)
+		}
+	}
+
+	// Rewrapping moves the data key under the current key, leaving the
+	// ciphertext to open with it as before
+	newID, newDataKey, err := rotated.Rewrap(keyID, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID != "2020-07" {
+		t.Errorf("want key ID %q; got %q", "2020-07", newID)
+	}
+	got, err := rotated.Open(newID, newDataKey, ciphertext, additional)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("want %q; got %q", plaintext, got)
+	}
+
+	// Once rewrapped it no longer needs the old key, which cannot open it
+	if _, err = old.Open(newID, newDataKey, ciphertext, additional); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("want %v; got %v", ErrUnknownKey, err)
+	}
+
+	// Anything tampered with fails to open
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+	for name, open := range map[string]func() ([]byte, error){
+		"Ciphertext":      func() ([]byte, error) { return old.Open(keyID, dataKey, tampered, additional) },
+		"Additional data": func() ([]byte, error) { return old.Open(keyID, dataKey, ciphertext, []byte("puddle")) },
+		"Data key":        func() ([]byte, error) { return old.Open(keyID, dataKey2, ciphertext, additional) },
+		"Truncated":       func() ([]byte, error) { return old.Open(keyID, dataKey, ciphertext[:4], additional) },
+	} {
+		if _, err = open(); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: want %v; got %v", name, ErrDecrypt, err)
+		}
+	}
+}
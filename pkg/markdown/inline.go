@@ -0,0 +1,332 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+)
+
+// inline renders the inline content of a block: code spans, emphasis,
+// strikethrough, links, images, autolinks, raw HTML, entities, backslash
+// escapes and hard line breaks.  Everything else is escaped text.
+func (r *renderer) inline(s string) string {
+	var sb strings.Builder
+	start := 0 // start of the pending run of plain text
+
+	flush := func(end int) {
+		sb.WriteString(html.EscapeString(s[start:end]))
+	}
+	emit := func(i int, out string, n int) int {
+		flush(i)
+		sb.WriteString(out)
+		start = i + n
+		return start
+	}
+
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch s[i] {
+		case '\\':
+			if len(rest) > 1 && rest[1] == '\n' {
+				i = emit(i, "<br>\n", 2)
+				continue
+			}
+			if len(rest) > 1 && isPunct(rest[1]) {
+				i = emit(i, html.EscapeString(rest[1:2]), 2)
+				continue
+			}
+
+		case '`':
+			n := run(rest, '`')
+			if end := closingRun(rest, n); end >= 0 {
+				code := strings.ReplaceAll(rest[n:end], "\n", " ")
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+					code = code[1 : len(code)-1]
+				}
+				i = emit(i, "<code>"+html.EscapeString(code)+"</code>", end+n)
+				continue
+			}
+			i += n // an unmatched run is literal text
+			continue
+
+		case '<':
+			if m := autolinkRX.FindStringSubmatch(rest); m != nil {
+				i = emit(i, link(m[1], "", html.EscapeString(m[1])), len(m[0]))
+				continue
+			}
+			if m := emailRX.FindStringSubmatch(rest); m != nil {
+				i = emit(i, link("mailto:"+m[1], "", html.EscapeString(m[1])), len(m[0]))
+				continue
+			}
+			if m := inlineHTMLRX.FindString(rest); m != "" {
+				i = emit(i, m, len(m))
+				continue
+			}
+
+		case '&':
+			if m := entityRX.FindString(rest); m != "" {
+				i = emit(i, m, len(m))
+				continue
+			}
+
+		case '!':
+			if len(rest) > 1 && rest[1] == '[' {
+				if n, text, dest, title := parseLink(rest[1:]); n > 0 {
+					out := "<img src=\"" + html.EscapeString(dest) + "\" alt=\"" + html.EscapeString(plain(text)) + "\""
+					if title != "" {
+						out += " title=\"" + html.EscapeString(title) + "\""
+					}
+					i = emit(i, out+">", n+1)
+					continue
+				}
+			}
+
+		case '[':
+			if n, text, dest, title := parseLink(rest); n > 0 {
+				i = emit(i, link(dest, title, r.inline(text)), n)
+				continue
+			}
+
+		case '*', '_', '~':
+			if n, out := r.emphasis(s, i); n > 0 {
+				i = emit(i, out, n)
+				continue
+			}
+			i += run(rest, s[i])
+			continue
+
+		case 'h', 'w':
+			if i == 0 || strings.IndexByte(" \t\n(*_~", s[i-1]) >= 0 {
+				if m := bareURLRX.FindString(rest); m != "" {
+					href := m
+					if strings.HasPrefix(m, "www.") {
+						href = "http://" + m
+					}
+					i = emit(i, link(href, "", html.EscapeString(m)), len(m))
+					continue
+				}
+			}
+
+		case '\n':
+			// Two or more trailing spaces make a hard line break
+			if i >= 2 && s[i-1] == ' ' && s[i-2] == ' ' {
+				flush(start + len(strings.TrimRight(s[start:i], " ")))
+				sb.WriteString("<br>\n")
+				start = i + 1
+				i++
+				continue
+			}
+		}
+		i++
+	}
+	flush(len(s))
+
+	return sb.String()
+}
+
+// emphasis renders emphasis, strong emphasis or strikethrough starting at
+// position i, returning the length of source consumed, or zero if the
+// delimiter run has no matching closer.  Underscores do not work within
+// words, so identifiers like snake_case are left alone.
+func (r *renderer) emphasis(s string, i int) (int, string) {
+	c := s[i]
+	n := run(s[i:], c)
+	if n > 3 || (c == '~' && n != 2) {
+		return 0, ""
+	}
+
+	// The opening run must be followed by text, and for underscores not be
+	// preceded by a letter or digit
+	if i+n >= len(s) || isSpace(s[i+n]) || (c == '_' && i > 0 && isWord(s[i-1])) {
+		return 0, ""
+	}
+
+	for j := i + n; j < len(s); {
+		if s[j] == '`' {
+			// Delimiters inside code spans don't count
+			m := run(s[j:], '`')
+			if end := closingRun(s[j:], m); end >= 0 {
+				j += end + m
+				continue
+			}
+			j += m
+			continue
+		}
+		if s[j] == '\\' {
+			j += 2
+			continue
+		}
+		if s[j] != c {
+			j++
+			continue
+		}
+
+		m := run(s[j:], c)
+		closes := m == n && !isSpace(s[j-1]) && !(c == '_' && j+m < len(s) && isWord(s[j+m]))
+		if !closes {
+			j += m
+			continue
+		}
+
+		inner := r.inline(s[i+n : j])
+		switch {
+		case c == '~':
+			inner = "<del>" + inner + "</del>"
+		case n == 1:
+			inner = "<em>" + inner + "</em>"
+		case n == 2:
+			inner = "<strong>" + inner + "</strong>"
+		default:
+			inner = "<em><strong>" + inner + "</strong></em>"
+		}
+		return j + m - i, inner
+	}
+	return 0, ""
+}
+
+// parseLink parses a link of the form [text](destination "title") at the
+// start of s, returning the length consumed or zero if there isn't one
+func parseLink(s string) (n int, text, dest, title string) {
+	// Find the closing bracket, allowing for nesting and escapes
+	depth, j := 0, 0
+	for ; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+			continue
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if j >= len(s) || j+1 >= len(s) || s[j+1] != '(' {
+		return 0, "", "", ""
+	}
+	text = s[1:j]
+
+	k := j + 2
+	k = skipSpace(s, k)
+
+	// The destination is either in angle brackets or runs to the next space
+	// with balanced parentheses
+	if k < len(s) && s[k] == '<' {
+		end := strings.IndexAny(s[k+1:], ">\n")
+		if end < 0 || s[k+1+end] != '>' {
+			return 0, "", "", ""
+		}
+		dest = s[k+1 : k+1+end]
+		k += end + 2
+	} else {
+		begin, parens := k, 0
+		for ; k < len(s) && !isSpace(s[k]); k++ {
+			if s[k] == '\\' && k+1 < len(s) {
+				k++
+				continue
+			}
+			if s[k] == '(' {
+				parens++
+			}
+			if s[k] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+		}
+		dest = s[begin:k]
+	}
+
+	k = skipSpace(s, k)
+	if k < len(s) && (s[k] == '"' || s[k] == '\'' || s[k] == '(') {
+		closer := s[k]
+		if closer == '(' {
+			closer = ')'
+		}
+		end := strings.IndexByte(s[k+1:], closer)
+		if end < 0 {
+			return 0, "", "", ""
+		}
+		title = unescape(s[k+1 : k+1+end])
+		k = skipSpace(s, k+end+2)
+	}
+	if k >= len(s) || s[k] != ')' {
+		return 0, "", "", ""
+	}
+
+	return k + 1, text, unescape(dest), title
+}
+
+// link renders an anchor element; content must already be HTML
+func link(href, title, content string) string {
+	out := "<a href=\"" + html.EscapeString(href) + "\""
+	if title != "" {
+		out += " title=\"" + html.EscapeString(title) + "\""
+	}
+	return out + ">" + content + "</a>"
+}
+
+// plain strips the common inline markup from text, for use as image alt text
+func plain(s string) string {
+	return strings.NewReplacer("*", "", "_", "", "`", "", "~~", "", "[", "", "]", "").Replace(unescape(s))
+}
+
+// unescape removes backslash escapes and decodes entities
+func unescape(s string) string {
+	var sb strings.Builder
+	for j := 0; j < len(s); j++ {
+		if s[j] == '\\' && j+1 < len(s) && isPunct(s[j+1]) {
+			j++
+		}
+		sb.WriteByte(s[j])
+	}
+	return html.UnescapeString(sb.String())
+}
+
+// run returns the length of the run of c at the start of s
+func run(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// closingRun finds the next run of exactly n backticks after an opening run
+// of that length at the start of s, returning its position or -1
+func closingRun(s string, n int) int {
+	for j := n; j < len(s); {
+		if s[j] != '`' {
+			j++
+			continue
+		}
+		m := run(s[j:], '`')
+		if m == n {
+			return j
+		}
+		j += m
+	}
+	return -1
+}
+
+// skipSpace returns the position of the next non-space character from k
+func skipSpace(s string, k int) int {
+	for k < len(s) && isSpace(s[k]) {
+		k++
+	}
+	return k
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isWord(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
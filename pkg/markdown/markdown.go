@@ -0,0 +1,438 @@
+// Package markdown renders a practical subset of CommonMark, plus the GitHub
+// tables, strikethrough and bare URL extensions, to sanitized HTML.
+//
+// Supported blocks are ATX and setext headings, paragraphs, block quotes,
+// bullet and ordered lists, fenced and indented code, thematic breaks, tables
+// and raw HTML.  Headings are given an id and an anchor link so they can be
+// linked to, and fenced code blocks are highlighted according to their info
+// string.  Link reference definitions are not supported.
+//
+// Raw HTML is allowed through to the output but, like everything else, is
+// passed through an allowlist sanitizer so content cannot inject scripts.
+
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"ptodd.org/snippetbox/pkg/highlight"
+	"ptodd.org/snippetbox/pkg/sanitize"
+)
+
+// Language is the snippet language for Markdown content
+const Language = "markdown"
+
+// aliases maps common fenced code info strings onto highlighter languages
+var aliases = map[string]string{
+	"golang":  "go",
+	"sh":      "shell",
+	"bash":    "shell",
+	"zsh":     "shell",
+	"console": "shell",
+	"yml":     "yaml",
+	"patch":   "diff",
+	"mysql":   "sql",
+}
+
+// Block level patterns
+var (
+	fenceRX     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`\\s]*)[^`]*$")
+	headingRX   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))??(?:[ \t]+#+)?[ \t]*$`)
+	breakRX     = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	setextRX    = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	quoteRX     = regexp.MustCompile(`^ {0,3}> ?`)
+	listRX      = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])(?:[ \t]+|$)`)
+	htmlBlockRX = regexp.MustCompile(`^ {0,3}<(?:/?[A-Za-z][A-Za-z0-9-]*(?:[\s/>]|$)|!--)`)
+	delimiterRX = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	blankRX     = regexp.MustCompile(`^[ \t]*$`)
+)
+
+// Inline patterns
+var (
+	autolinkRX   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*)>`)
+	emailRX      = regexp.MustCompile(`^<([A-Za-z0-9.!#$%&'*+/=?^_{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9.-]*[A-Za-z0-9])?)>`)
+	inlineHTMLRX = regexp.MustCompile(`^(?:<!--[\s\S]*?-->|</?[A-Za-z][A-Za-z0-9-]*(?:\s+[A-Za-z_:][\w:.-]*(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*\s*/?>)`)
+	entityRX     = regexp.MustCompile(`^&(?:#\d{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	bareURLRX    = regexp.MustCompile(`^(?:https?://|www\.)[^\s<]*[^\s<?!.,:*_~'")\]]`)
+	slugRX       = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// renderer accumulates the HTML for a document
+type renderer struct {
+	out   strings.Builder
+	slugs map[string]int // number of times each heading id has been used
+}
+
+// Render converts Markdown source into sanitized HTML
+func Render(src string) string {
+	r := &renderer{slugs: map[string]int{}}
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	r.blocks(strings.Split(src, "\n"), false)
+	return sanitize.HTML(r.out.String())
+}
+
+// blocks renders a sequence of lines as block level elements.  In a tight
+// list paragraphs are rendered without <p> tags.
+func (r *renderer) blocks(lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case blankRX.MatchString(line):
+			i++
+		case fenceRX.MatchString(line):
+			i = r.fence(lines, i)
+		case headingRX.MatchString(line):
+			m := headingRX.FindStringSubmatch(line)
+			r.heading(len(m[1]), m[2])
+			i++
+		case breakRX.MatchString(line):
+			r.out.WriteString("<hr>\n")
+			i++
+		case quoteRX.MatchString(line):
+			i = r.quote(lines, i)
+		case listRX.MatchString(line):
+			i = r.list(lines, i)
+		case indent(line) >= 4:
+			i = r.indentedCode(lines, i)
+		case htmlBlockRX.MatchString(line):
+			i = r.htmlBlock(lines, i)
+		case i+1 < len(lines) && strings.Contains(line, "|") && delimiterRX.MatchString(lines[i+1]):
+			i = r.table(lines, i)
+		default:
+			i = r.paragraph(lines, i, tight)
+		}
+	}
+}
+
+// interrupts reports whether a line starts a block which ends a paragraph
+func interrupts(line string) bool {
+	return blankRX.MatchString(line) || fenceRX.MatchString(line) || headingRX.MatchString(line) ||
+		breakRX.MatchString(line) || quoteRX.MatchString(line) || listRX.MatchString(line) ||
+		htmlBlockRX.MatchString(line)
+}
+
+// paragraph renders a paragraph, or a setext heading if it is underlined
+func (r *renderer) paragraph(lines []string, i int, tight bool) int {
+	var text []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if len(text) > 0 {
+			if m := setextRX.FindStringSubmatch(line); m != nil {
+				level := 2
+				if m[1][0] == '=' {
+					level = 1
+				}
+				r.heading(level, strings.Join(text, "\n"))
+				return i + 1
+			}
+			if interrupts(line) {
+				break
+			}
+		}
+		text = append(text, strings.TrimLeft(line, " \t"))
+	}
+
+	content := r.inline(strings.TrimRight(strings.Join(text, "\n"), " \t"))
+	if tight {
+		r.out.WriteString(content + "\n")
+	} else {
+		r.out.WriteString("<p>" + content + "</p>\n")
+	}
+	return i
+}
+
+// heading renders a heading with an id and an anchor linking to it
+func (r *renderer) heading(level int, text string) {
+	slug := strings.Trim(slugRX.ReplaceAllString(strings.ToLower(text), "-"), "-")
+	if slug == "" {
+		slug = "section"
+	}
+	if n := r.slugs[slug]; n > 0 {
+		r.slugs[slug]++
+		slug += "-" + strconv.Itoa(n)
+	} else {
+		r.slugs[slug] = 1
+	}
+
+	h := "h" + strconv.Itoa(level)
+	r.out.WriteString("<" + h + " id=\"" + slug + "\">")
+	r.out.WriteString("<a class=\"anchor\" href=\"#" + slug + "\">#</a> ")
+	r.out.WriteString(r.inline(strings.TrimSpace(text)))
+	r.out.WriteString("</" + h + ">\n")
+}
+
+// fence renders a fenced code block, highlighted using its info string
+func (r *renderer) fence(lines []string, i int) int {
+	m := fenceRX.FindStringSubmatch(lines[i])
+	width, marker := len(m[1]), m[2]
+	closing := regexp.MustCompile(`^ {0,3}` + regexp.QuoteMeta(marker[:1]) + `{` + strconv.Itoa(len(marker)) + `,}[ \t]*$`)
+
+	lang := strings.ToLower(html.UnescapeString(m[3]))
+	if alias, ok := aliases[lang]; ok {
+		lang = alias
+	}
+
+	var code []string
+	for i++; i < len(lines) && !closing.MatchString(lines[i]); i++ {
+		code = append(code, strip(lines[i], width))
+	}
+
+	r.code(lang, code)
+	return i + 1
+}
+
+// indentedCode renders a code block indented by four or more spaces
+func (r *renderer) indentedCode(lines []string, i int) int {
+	var code []string
+	for ; i < len(lines) && (indent(lines[i]) >= 4 || blankRX.MatchString(lines[i])); i++ {
+		code = append(code, strip(lines[i], 4))
+	}
+	for len(code) > 0 && blankRX.MatchString(code[len(code)-1]) {
+		code = code[:len(code)-1]
+	}
+
+	r.code("", code)
+	return i
+}
+
+// code renders the lines of a code block
+func (r *renderer) code(lang string, code []string) {
+	src := strings.Join(code, "\n")
+	if len(code) > 0 {
+		src += "\n"
+	}
+
+	r.out.WriteString("<pre><code")
+	if lang != "" {
+		r.out.WriteString(" class=\"language-" + html.EscapeString(lang) + "\"")
+	}
+	r.out.WriteString(">" + highlight.HTML(lang, src) + "</code></pre>\n")
+}
+
+// quote renders a block quote, including any lazy continuation lines
+func (r *renderer) quote(lines []string, i int) int {
+	var inner []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if loc := quoteRX.FindStringIndex(line); loc != nil {
+			inner = append(inner, line[loc[1]:])
+			continue
+		}
+		if len(inner) == 0 || blankRX.MatchString(inner[len(inner)-1]) || interrupts(line) {
+			break
+		}
+		inner = append(inner, line)
+	}
+
+	r.out.WriteString("<blockquote>\n")
+	r.blocks(inner, false)
+	r.out.WriteString("</blockquote>\n")
+	return i
+}
+
+// list renders a bullet or ordered list.  The list is loose, and its items'
+// paragraphs wrapped in <p> tags, if any of its items are separated by blank
+// lines.
+func (r *renderer) list(lines []string, i int) int {
+	first := listRX.FindStringSubmatch(lines[i])
+	marker := first[2]
+	ordered := marker[0] >= '0' && marker[0] <= '9'
+	kind := marker[len(marker)-1:] // the bullet, or the delimiter after the number
+
+	var items [][]string
+	loose := false
+
+	for i < len(lines) {
+		loc := listRX.FindStringSubmatchIndex(lines[i])
+		if loc == nil {
+			break
+		}
+		m := lines[i][loc[4]:loc[5]]
+		if m[len(m)-1:] != kind || (m[0] >= '0' && m[0] <= '9') != ordered {
+			break
+		}
+
+		// Content is indented to the first character after the marker
+		content := lines[i][loc[1]:]
+		width := loc[1]
+		if blankRX.MatchString(content) {
+			width = loc[5] + 1
+		} else if loc[1]-loc[5] > 4 {
+			width = loc[5] + 1
+			content = lines[i][width:]
+		}
+
+		item := []string{content}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			previous := item[len(item)-1]
+			switch {
+			case blankRX.MatchString(line):
+				item = append(item, "")
+				continue
+			case indent(line) >= width:
+				item = append(item, strip(line, width))
+				continue
+			case !blankRX.MatchString(previous) && !interrupts(line):
+				// Lazy continuation of a paragraph
+				item = append(item, line)
+				continue
+			}
+			break
+		}
+
+		// Blank lines between items make the list loose, but those after
+		// the last one do not
+		for len(item) > 1 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+			if i < len(lines) && listRX.MatchString(lines[i]) {
+				loose = true
+			}
+		}
+		for j := 1; j < len(item) && !loose; j++ {
+			loose = item[j] == "" && j+1 < len(item)
+		}
+		items = append(items, item)
+	}
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+		if n, _ := strconv.Atoi(strings.TrimRight(marker, ".)")); n != 1 {
+			r.out.WriteString("<ol start=\"" + strconv.Itoa(n) + "\">\n")
+		} else {
+			r.out.WriteString("<ol>\n")
+		}
+	} else {
+		r.out.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		r.out.WriteString("<li>")
+		r.blocks(item, !loose)
+		r.out.WriteString("</li>\n")
+	}
+	r.out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// htmlBlock passes raw HTML through up to the next blank line
+func (r *renderer) htmlBlock(lines []string, i int) int {
+	for ; i < len(lines) && !blankRX.MatchString(lines[i]); i++ {
+		r.out.WriteString(lines[i] + "\n")
+	}
+	return i
+}
+
+// table renders a table with a header row, a delimiter row giving the column
+// alignment, and body rows up to the next blank line
+func (r *renderer) table(lines []string, i int) int {
+	header := cells(lines[i])
+
+	var align []string
+	for _, d := range cells(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(d, ":") && strings.HasSuffix(d, ":"):
+			align = append(align, "center")
+		case strings.HasSuffix(d, ":"):
+			align = append(align, "right")
+		case strings.HasPrefix(d, ":"):
+			align = append(align, "left")
+		default:
+			align = append(align, "")
+		}
+	}
+
+	row := func(tag string, cols []string) {
+		r.out.WriteString("<tr>")
+		for j := range header {
+			r.out.WriteString("<" + tag)
+			if j < len(align) && align[j] != "" {
+				r.out.WriteString(" align=\"" + align[j] + "\"")
+			}
+			r.out.WriteString(">")
+			if j < len(cols) {
+				r.out.WriteString(r.inline(cols[j]))
+			}
+			r.out.WriteString("</" + tag + ">")
+		}
+		r.out.WriteString("</tr>\n")
+	}
+
+	r.out.WriteString("<table>\n<thead>\n")
+	row("th", header)
+	r.out.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines) && !blankRX.MatchString(lines[i]) && !interrupts(lines[i]); i++ {
+		row("td", cells(lines[i]))
+	}
+	r.out.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+// cells splits a table row into its trimmed cells.  Pipes may be escaped
+// with a backslash to include them in a cell.
+func cells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cols []string
+	var cell strings.Builder
+	for j := 0; j < len(line); j++ {
+		switch {
+		case line[j] == '\\' && j+1 < len(line) && line[j+1] == '|':
+			cell.WriteByte('|')
+			j++
+		case line[j] == '|':
+			cols = append(cols, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[j])
+		}
+	}
+	return append(cols, strings.TrimSpace(cell.String()))
+}
+
+// indent returns the width of a line's leading whitespace, with tabs
+// advancing to the next multiple of four columns
+func indent(line string) int {
+	n := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4 - n%4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// strip removes up to width columns of leading whitespace from a line
+func strip(line string, width int) string {
+	n := 0
+	for j, c := range line {
+		if n >= width {
+			return line[j:]
+		}
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			if n+4-n%4 > width {
+				// Keep the rest of a partially consumed tab as spaces
+				return strings.Repeat(" ", n+4-n%4-width) + line[j+1:]
+			}
+			n += 4 - n%4
+		default:
+			return line[j:]
+		}
+	}
+	return ""
+}
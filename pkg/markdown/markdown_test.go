@@ -0,0 +1,134 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Paragraphs",
+			src:  "Hello\nworld\n\nBye",
+			want: "<p>Hello\nworld</p>\n<p>Bye</p>\n",
+		}, {
+			name: "Headings with anchors",
+			src:  "# Set up\n\nSet up\n------\n### Hash #",
+			want: `<h1 id="set-up"><a class="anchor" href="#set-up">#</a> Set up</h1>` + "\n" +
+				`<h2 id="set-up-1"><a class="anchor" href="#set-up-1">#</a> Set up</h2>` + "\n" +
+				`<h3 id="hash"><a class="anchor" href="#hash">#</a> Hash</h3>` + "\n",
+		}, {
+			name: "Not a heading",
+			src:  "#hashtag",
+			want: "<p>#hashtag</p>\n",
+		}, {
+			name: "Emphasis",
+			src:  "*a* **b** ***c*** _d_ ~~e~~ snake_case_name 2 * 3 * 4",
+			want: "<p><em>a</em> <strong>b</strong> <em><strong>c</strong></em> <em>d</em> <del>e</del> snake_case_name 2 * 3 * 4</p>\n",
+		}, {
+			name: "Nested emphasis",
+			src:  "**bold *and* italic**",
+			want: "<p><strong>bold <em>and</em> italic</strong></p>\n",
+		}, {
+			name: "Code spans",
+			src:  "Run `` a`b `` and `<x>` but not *`*`*",
+			want: "<p>Run <code>a`b</code> and <code>&lt;x&gt;</code> but not <em><code>*</code></em></p>\n",
+		}, {
+			name: "Escapes and entities",
+			src:  `\*not\* &copy; & <`,
+			want: "<p>*not* © &amp; &lt;</p>\n",
+		}, {
+			name: "Links and images",
+			src:  `[the *docs*](https://example.com/a_(b) "Docs") ![a *cat*](/cat.png)`,
+			want: `<p><a href="https://example.com/a_(b)" title="Docs" rel="nofollow noopener noreferrer">the <em>docs</em></a> <img src="/cat.png" alt="a cat"></p>` + "\n",
+		}, {
+			name: "Autolinks",
+			src:  "<https://a.example> <me@example.com> see www.example.com/x.",
+			want: `<p><a href="https://a.example" rel="nofollow noopener noreferrer">https://a.example</a> <a href="mailto:me@example.com" rel="nofollow noopener noreferrer">me@example.com</a> see <a href="http://www.example.com/x" rel="nofollow noopener noreferrer">www.example.com/x</a>.</p>` + "\n",
+		}, {
+			name: "Hard breaks",
+			src:  "a  \nb\\\nc",
+			want: "<p>a<br>\nb<br>\nc</p>\n",
+		}, {
+			name: "Fenced code is highlighted",
+			src:  "```golang\nreturn nil\n```",
+			want: `<pre><code class="language-go"><span class="hl-kw">return</span> <span class="hl-lit">nil</span>` + "\n</code></pre>\n",
+		}, {
+			name: "Fenced code with unknown language",
+			src:  "~~~~ brainfuck\n<>\n```\n~~~~",
+			want: `<pre><code class="language-brainfuck">&lt;&gt;` + "\n```\n</code></pre>\n",
+		}, {
+			name: "Indented code",
+			src:  "    a <b>\n\n\tc\n",
+			want: "<pre><code>a &lt;b&gt;\n\nc\n</code></pre>\n",
+		}, {
+			name: "Block quote with lazy continuation",
+			src:  "> quoted\nlazy\n\nafter",
+			want: "<blockquote>\n<p>quoted\nlazy</p>\n</blockquote>\n<p>after</p>\n",
+		}, {
+			name: "Tight nested lists",
+			src:  "- one\n- two\n  1. a\n  2. b\n- three",
+			want: "<ul>\n<li>one\n</li>\n<li>two\n<ol>\n<li>a\n</li>\n<li>b\n</li>\n</ol>\n</li>\n<li>three\n</li>\n</ul>\n",
+		}, {
+			name: "Loose ordered list",
+			src:  "3. one\n\n4. two",
+			want: "<ol start=\"3\">\n<li><p>one</p>\n</li>\n<li><p>two</p>\n</li>\n</ol>\n",
+		}, {
+			name: "Thematic break",
+			src:  "a\n\n* * *\n\nb",
+			want: "<p>a</p>\n<hr>\n<p>b</p>\n",
+		}, {
+			name: "Table",
+			src:  "| Name | Size |\n|:-----|-----:|\n| a \\| b | 1 |",
+			want: "<table>\n<thead>\n<tr><th align=\"left\">Name</th><th align=\"right\">Size</th></tr>\n</thead>\n<tbody>\n<tr><td align=\"left\">a | b</td><td align=\"right\">1</td></tr>\n</tbody>\n</table>\n",
+		}, {
+			name: "Safe raw HTML",
+			src:  "<details>\n<summary>More</summary>\n\nHidden <kbd>Ctrl</kbd>\n\n</details>",
+			want: "<details>\n<summary>More</summary>\n<p>Hidden <kbd>Ctrl</kbd></p>\n</details>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.src); got != tt.want {
+				t.Errorf("want\n%q\ngot\n%q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRenderUnsafe(t *testing.T) {
+
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"Script block", "<script>alert(1)</script>"},
+		{"Inline script", "Hi <script>alert(1)</script> there"},
+		{"Event handler", `<img src=x onerror="alert(1)">`},
+		{"Script link", "[click](javascript:alert(1))"},
+		{"Encoded script link", "[click](&#106;avascript:alert(1))"},
+		{"Script autolink", "<javascript:alert(1)>"},
+		{"Script image", "![x](javascript:alert(1))"},
+		{"Attribute breakout", `[x](https://a.example/" onmouseover="alert(1))`},
+		{"Title breakout", `[x](/a "\" onmouseover=alert(1) x=\"")`},
+		{"Fence info string", "```\"><script>alert(1)</script>\nx\n```"},
+		{"Iframe", "<iframe src=https://evil.example></iframe>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.ToLower(Render(tt.src))
+			for _, bad := range []string{"<script", "<iframe", "=\"javascript:", "onerror=", "onmouseover=\""} {
+				if strings.Contains(got, bad) {
+					t.Errorf("output contains %q: %q", bad, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package mock
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"ptodd.org/snippetbox/pkg/blob"
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+var mockAttachments = []*models.Attachment{
+	{
+		ID:          1,
+		SnippetID:   1,
+		UserID:      1,
+		Name:        "pond.log",
+		ContentType: "text/plain; charset=utf-8",
+		Size:        19,
+		Key:         "mockPondLogKey",
+		Created:     time.Now(),
+	}, {
+		ID:          2,
+		SnippetID:   3,
+		UserID:      1,
+		Name:        "frog.log",
+		ContentType: "text/plain; charset=utf-8",
+		Size:        16,
+		Key:         "mockFrogLogKey",
+		Created:     time.Now(),
+	}, {
+		ID:          4,
+		SnippetID:   4,
+		UserID:      1,
+		Name:        "splash.log",
+		ContentType: "text/plain; charset=utf-8",
+		Size:        13,
+		Key:         "mockSplashLogKey",
+		Created:     time.Now(),
+	},
+}
+
+// mockBlobs holds the bytes of the mock attachments by key
+var mockBlobs = map[string]string{
+	"mockPondLogKey":   "the pond was silent",
+	"mockFrogLogKey":   "a frog jumped in",
+	"mockSplashLogKey": "silence again",
+}
+
+// mockUsage is the attachment storage used by every user: 95 MB
+const mockUsage = 95 << 20
+
+// AttachmentModel mocks the attachment model
+type AttachmentModel struct{}
+
+// Insert mocks recording an attachment
+func (m *AttachmentModel) Insert(snippetID, userID int, name, contentType, key string, size int64) (int, error) {
+	return 3, nil
+}
+
+// Get mocks retrieving an attachment
+func (m *AttachmentModel) Get(id int) (*models.Attachment, error) {
+	for _, a := range mockAttachments {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// ForSnippet mocks listing a snippet's attachments
+func (m *AttachmentModel) ForSnippet(snippetID int) ([]*models.Attachment, error) {
+	attachments := []*models.Attachment{}
+	for _, a := range mockAttachments {
+		if a.SnippetID == snippetID {
+			attachments = append(attachments, a)
+		}
+	}
+	return attachments, nil
+}
+
+// Usage mocks totalling a user's attachments
+func (m *AttachmentModel) Usage(userID int) (int64, error) {
+	return mockUsage, nil
+}
+
+// Orphans mocks listing attachments left behind by removed snippets, of
+// which there are none
+func (m *AttachmentModel) Orphans(limit int) ([]*models.Attachment, error) {
+	return []*models.Attachment{}, nil
+}
+
+// Delete mocks removing the record of an attachment
+func (m *AttachmentModel) Delete(id int) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// BlobStore mocks a blob store holding the bytes of the mock attachments.
+// Blobs put into it are read and discarded.
+type BlobStore struct{}
+
+// Put mocks storing a blob
+func (s *BlobStore) Put(key string, r io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// Open mocks reading a blob
+func (s *BlobStore) Open(key string) (io.ReadCloser, error) {
+	content, ok := mockBlobs[key]
+	if !ok {
+		return nil, blob.ErrNotFound
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+// Delete mocks removing a blob
+func (s *BlobStore) Delete(key string) error {
+	return nil
+}
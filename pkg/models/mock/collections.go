@@ -0,0 +1,122 @@
+package mock
+
+import (
+	"time"
+
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+// mockCollections holds Alice's (user 1) private collection of the first
+// snippet and her shared one of the unlisted snippet and the fork, and a
+// collection of another user's
+var mockCollections = []*models.Collection{
+	{
+		ID:         1,
+		UserID:     1,
+		Name:       "Haiku",
+		Visibility: models.Private,
+		Slug:       "gjXqmccy3FN3z-0_EIkKrA",
+		Created:    time.Now(),
+	}, {
+		ID:         2,
+		UserID:     1,
+		Name:       "Frogs",
+		Visibility: models.Unlisted,
+		Slug:       "ELQGnwjXNZPzZ7EFpU0MOQ",
+		Created:    time.Now(),
+	}, {
+		ID:         3,
+		UserID:     2,
+		Name:       "Ponds",
+		Visibility: models.Private,
+		Slug:       "0_aMf1sjIoazQWxhEOgohw",
+		Created:    time.Now(),
+	},
+}
+
+// mockCollectionSnippets holds the IDs of the snippets in each collection
+var mockCollectionSnippets = map[int][]int{
+	1: {1},
+	2: {3, 7},
+}
+
+// CollectionModel mocks the collection model
+type CollectionModel struct{}
+
+// Insert mocks creating a collection
+func (m *CollectionModel) Insert(userID int, name, visibility string) (int, error) {
+	return 4, nil
+}
+
+// Get mocks retrieving a collection
+func (m *CollectionModel) Get(id int) (*models.Collection, error) {
+	for _, c := range mockCollections {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// BySlug mocks retrieving a collection by its slug
+func (m *CollectionModel) BySlug(slug string) (*models.Collection, error) {
+	for _, c := range mockCollections {
+		if c.Slug == slug {
+			return c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// ByUser mocks listing a user's collections
+func (m *CollectionModel) ByUser(userID int) ([]*models.Collection, error) {
+	collections := []*models.Collection{}
+	for _, c := range mockCollections {
+		if c.UserID == userID {
+			sized := *c
+			sized.Size = len(mockCollectionSnippets[c.ID])
+			collections = append(collections, &sized)
+		}
+	}
+	return collections, nil
+}
+
+// ForSnippet mocks listing the collections of a user's which a snippet is in
+func (m *CollectionModel) ForSnippet(snippetID, userID int) ([]*models.Collection, error) {
+	collections := []*models.Collection{}
+	for _, c := range mockCollections {
+		if c.UserID != userID {
+			continue
+		}
+		for _, id := range mockCollectionSnippets[c.ID] {
+			if id == snippetID {
+				collections = append(collections, c)
+			}
+		}
+	}
+	return collections, nil
+}
+
+// Update mocks renaming a collection
+func (m *CollectionModel) Update(id, userID int, name, visibility string) error {
+	return nil
+}
+
+// Delete mocks removing a collection
+func (m *CollectionModel) Delete(id, userID int) error {
+	c, err := m.Get(id)
+	if err != nil || c.UserID != userID {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// Add mocks putting a snippet into a collection
+func (m *CollectionModel) Add(collectionID, snippetID int) error {
+	return nil
+}
+
+// Remove mocks taking a snippet out of a collection
+func (m *CollectionModel) Remove(collectionID, snippetID int) error {
+	return nil
+}
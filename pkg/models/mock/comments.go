@@ -0,0 +1,104 @@
+package mock
+
+import (
+	"time"
+
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+var mockReply = &models.Comment{
+	ID:        2,
+	SnippetID: 1,
+	UserID:    1,
+	Author:    "Alice",
+	ParentID:  1,
+	Revision:  2,
+	Body:      "Thank you",
+	Created:   time.Now(),
+}
+
+// mockComments holds the comments which are not replies.  Those on the first
+// snippet are one anchored to its only line, one on an earlier revision and
+// one not anchored at all, all by another user; the fork has one by its owner
+// anchored to its second file.
+var mockComments = []*models.Comment{
+	{
+		ID:        1,
+		SnippetID: 1,
+		UserID:    2,
+		Author:    "Bob",
+		Revision:  2,
+		FirstLine: 1,
+		LastLine:  1,
+		Body:      "What a lovely line",
+		Created:   time.Now(),
+		Replies:   []*models.Comment{mockReply},
+	}, {
+		ID:        3,
+		SnippetID: 1,
+		UserID:    2,
+		Author:    "Bob",
+		Revision:  1,
+		FirstLine: 1,
+		LastLine:  1,
+		Body:      "This line could be quieter",
+		Created:   time.Now(),
+	}, {
+		ID:        4,
+		SnippetID: 1,
+		UserID:    2,
+		Author:    "Bob",
+		Revision:  2,
+		Body:      "Where is the frog?",
+		Created:   time.Now(),
+	}, {
+		ID:        5,
+		SnippetID: 7,
+		UserID:    2,
+		Author:    "Bob",
+		Revision:  1,
+		File:      1,
+		FirstLine: 1,
+		LastLine:  1,
+		Body:      "The frog needs a func",
+		Created:   time.Now(),
+	},
+}
+
+// CommentModel mocks the comment model
+type CommentModel struct{}
+
+// Insert mocks adding a comment
+func (m *CommentModel) Insert(c *models.Comment) (int, error) {
+	return 6, nil
+}
+
+// Get mocks retrieving a comment
+func (m *CommentModel) Get(id int) (*models.Comment, error) {
+	if id == mockReply.ID {
+		return mockReply, nil
+	}
+	for _, c := range mockComments {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// ForSnippet mocks listing a snippet's comments
+func (m *CommentModel) ForSnippet(snippetID int) ([]*models.Comment, error) {
+	comments := []*models.Comment{}
+	for _, c := range mockComments {
+		if c.SnippetID == snippetID {
+			comments = append(comments, c)
+		}
+	}
+	return comments, nil
+}
+
+// Delete mocks removing a comment
+func (m *CommentModel) Delete(id int) error {
+	_, err := m.Get(id)
+	return err
+}
@@ -1,38 +1,356 @@
 package mock
 
 import (
+	"io"
+	"strings"
 	"time"
 
 	"ptodd.org/snippetbox/pkg/models"
 )
 
 var mockSnippet = &models.Snippet{
-	ID:      1,
-	Title:   "An old silent pond",
-	Content: "An old silent pond...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:         1,
+	UserID:     1,
+	Title:      "An old silent pond",
+	Content:    "An old silent pond...",
+	Language:   "text",
+	Visibility: models.Public,
+	Slug:       "bWFW3PN7T8nE0D5kb2Vx1g",
+	Stars:      1,
+	Revision:   2,
+	Created:    time.Now(),
+	Expires:    time.Now(),
 }
 
+var mockUnlisted = &models.Snippet{
+	ID:         3,
+	UserID:     1,
+	Title:      "A frog jumps in",
+	Content:    "A frog jumps into the pond...",
+	Language:   "text",
+	Visibility: models.Unlisted,
+	Slug:       "K3xhT0mVv1a9pQwYc7ZrEw",
+	Revision:   1,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+var mockPrivate = &models.Snippet{
+	ID:         4,
+	UserID:     1,
+	Title:      "Splash! Silence again",
+	Content:    "Splash! Silence again.",
+	Language:   "text",
+	Visibility: models.Private,
+	Slug:       "Q8bN2sLd5JfX0uHy4GtR6A",
+	Revision:   1,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+var mockBurn = &models.Snippet{
+	ID:         5,
+	UserID:     1,
+	Title:      "Database password",
+	Content:    "correct horse battery staple",
+	Language:   "text",
+	Visibility: models.Unlisted,
+	Slug:       "Zx4pW9cTq2Lm7Nb1Vd8Hs0",
+	MaxViews:   1,
+	Revision:   1,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+var mockFork = &models.Snippet{
+	ID:         7,
+	UserID:     2,
+	ParentID:   1,
+	Title:      "An old silent pond, revisited",
+	Filename:   "pond.txt",
+	Content:    "An old silent pond... a frog jumps in",
+	Language:   "text",
+	Visibility: models.Public,
+	Slug:       "Fk7Lm2Np4Qr6St8Uv0Wx1y",
+	Stars:      1,
+	Revision:   1,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+}
+
+// mockFiles holds the files of each snippet after the first
+var mockFiles = map[int][]*models.File{
+	7: {{Name: "frog.go", Language: "go", Content: "package frog"}},
+}
+
+// mockSnippets holds the snippets which can be retrieved individually
+var mockSnippets = []*models.Snippet{mockSnippet, mockUnlisted, mockPrivate, mockBurn, mockFork}
+
+var mockRevisions = []*models.Revision{
+	{
+		SnippetID: 1,
+		Number:    2,
+		UserID:    1,
+		Title:     "An old silent pond",
+		Files:     []*models.File{{Language: "text", Content: "An old silent pond..."}},
+		Created:   time.Now(),
+	}, {
+		SnippetID: 1,
+		Number:    1,
+		UserID:    1,
+		Title:     "An old pond",
+		Files:     []*models.File{{Language: "text", Content: "An old pond..."}},
+		Created:   time.Now(),
+	},
+}
+
+// mockIdentical holds the number of other snippets with the same content as
+// each snippet, which are not themselves mocked
+var mockIdentical = map[int]int{1: 2}
+
 // SnippetModel is a mock structure for the snippet model
 type SnippetModel struct{}
 
 // Insert is a mock insert handler
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+func (m *SnippetModel) Insert(userID int, title string, files []*models.File, visibility string, expires time.Time, maxViews, parentID int) (int, error) {
 	return 2, nil
 }
 
 // Get is a mock get handler
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+	for _, s := range mockSnippets {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// Files is a mock handler for retrieving the files of a snippet
+func (m *SnippetModel) Files(id int) ([]*models.File, error) {
+	s, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	files := []*models.File{{Name: s.Filename, Language: s.Language, Content: s.Content}}
+	return append(files, mockFiles[id]...), nil
+}
+
+// WriteContent is a mock handler for writing the content of a snippet
+func (m *SnippetModel) WriteContent(id int, w io.Writer) error {
+	s, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, s.Content)
+	return err
+}
+
+// View is a mock handler for viewing a snippet which counts the view against
+// a copy so that every test sees the snippet unviewed
+func (m *SnippetModel) View(id int) (*models.Snippet, error) {
+	s, err := m.Get(id)
+	if err != nil || s.MaxViews == 0 {
+		return s, err
+	}
+	viewed := *s
+	viewed.Views++
+	return &viewed, nil
+}
+
+// AddViews is a mock handler for counting views which discards them
+func (m *SnippetModel) AddViews(counts map[int]int) error {
+	return nil
+}
+
+// BySlug is a mock handler for retrieving a snippet by its slug
+func (m *SnippetModel) BySlug(slug string) (*models.Snippet, error) {
+	for _, s := range mockSnippets {
+		if s.Slug == slug {
+			return s, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// Latest is a mock latest handler.  Only the first page contains a snippet
+// as the others are not public.
+func (m *SnippetModel) Latest(page models.Page) ([]*models.Snippet, error) {
+	if page.Before != nil || page.After != nil {
+		return []*models.Snippet{}, nil
+	}
+	return []*models.Snippet{mockSnippet}, nil
+}
+
+// ByUser is a mock handler for listing a user's snippets
+func (m *SnippetModel) ByUser(userID int, page models.Page) ([]*models.Snippet, error) {
+	snippets := []*models.Snippet{}
+	if page.Before != nil || page.After != nil {
+		return snippets, nil
+	}
+	for _, s := range mockSnippets {
+		if s.UserID == userID {
+			snippets = append(snippets, s)
+		}
+	}
+	return snippets, nil
+}
+
+// Starred is a mock handler for listing the snippets a user has starred,
+// which for Alice (user 1) are the first snippet and the fork of it
+func (m *SnippetModel) Starred(userID int, page models.Page) ([]*models.Snippet, error) {
+	if userID != 1 || page.Before != nil || page.After != nil {
+		return []*models.Snippet{}, nil
+	}
+	return []*models.Snippet{mockFork, mockSnippet}, nil
+}
+
+// InCollection is a mock handler for listing the snippets in a collection
+// which a user can see
+func (m *SnippetModel) InCollection(collectionID, userID int, page models.Page) ([]*models.Snippet, error) {
+	snippets := []*models.Snippet{}
+	if page.Before != nil || page.After != nil {
+		return snippets, nil
+	}
+	for _, id := range mockCollectionSnippets[collectionID] {
+		s, err := m.Get(id)
+		if err != nil {
+			continue
+		}
+		if (s.Visibility != models.Private && s.MaxViews == 0) || s.UserID == userID {
+			snippets = append(snippets, s)
+		}
+	}
+	return snippets, nil
+}
+
+// Forks is a mock handler for listing the forks of a snippet
+func (m *SnippetModel) Forks(id, userID int) ([]*models.Snippet, error) {
+	forks := []*models.Snippet{}
+	for _, s := range mockSnippets {
+		if s.ParentID == id && ((s.Visibility == models.Public && s.MaxViews == 0) || s.UserID == userID) {
+			forks = append(forks, s)
+		}
+	}
+	return forks, nil
+}
+
+// Identical is a mock handler for counting snippets with the same content
+func (m *SnippetModel) Identical(id, userID int) (int, error) {
+	return mockIdentical[id], nil
+}
+
+// Update is a mock update handler
+func (m *SnippetModel) Update(id, userID int, title string, files []*models.File, visibility string) error {
+	for _, s := range mockSnippets {
+		if s.ID == id {
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+// Revisions is a mock handler for listing a snippet's history
+func (m *SnippetModel) Revisions(id int) ([]*models.Revision, error) {
 	switch id {
 	case 1:
-		return mockSnippet, nil
+		return mockRevisions, nil
 	default:
-		return nil, models.ErrNoRecord
+		return []*models.Revision{}, nil
 	}
 }
 
-// Latest is a mock latest handler
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	return []*models.Snippet{mockSnippet}, nil
+// Revision is a mock handler for retrieving a single revision
+func (m *SnippetModel) Revision(id, number int) (*models.Revision, error) {
+	for _, r := range mockRevisions {
+		if r.SnippetID == id && r.Number == number {
+			return r, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+// Delete is a mock handler for moving a snippet to the trash
+func (m *SnippetModel) Delete(id, userID int) error {
+	if id == 1 && userID == 1 {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+// Restore is a mock handler for taking a snippet out of the trash
+func (m *SnippetModel) Restore(id, userID int) error {
+	if id == 1 && userID == 1 {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+// Trash is a mock handler for listing a user's trashed snippets
+func (m *SnippetModel) Trash(userID int) ([]*models.Snippet, error) {
+	return []*models.Snippet{}, nil
+}
+
+// Purge is a mock handler for permanently removing a trashed snippet
+func (m *SnippetModel) Purge(id, userID int) error {
+	if id == 1 && userID == 1 {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+// PurgeTrash is a mock handler for emptying the trash of old snippets
+func (m *SnippetModel) PurgeTrash(retention time.Duration) (int, error) {
+	return 0, nil
+}
+
+// ByTag is a mock handler for listing the snippets with a tag
+func (m *SnippetModel) ByTag(tag string, page models.Page) ([]*models.Snippet, error) {
+	if tag == "haiku" && page.Before == nil && page.After == nil {
+		return []*models.Snippet{mockSnippet}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+// Search is a mock handler for full-text searches which matches any query
+// containing "pond", and those containing "splash" by the owner of the
+// private snippet restricted to their own snippets
+func (m *SnippetModel) Search(q models.SearchQuery) ([]*models.Snippet, error) {
+	if strings.Contains(strings.ToLower(q.Terms), "splash") && q.UserID == mockPrivate.UserID && q.ViewerID == q.UserID {
+		return []*models.Snippet{mockPrivate}, nil
+	}
+	if strings.Contains(strings.ToLower(q.Terms), "pond") && (q.UserID == 0 || q.UserID == 1) && q.Offset == 0 {
+		return []*models.Snippet{mockSnippet}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+var mockTombstone = &models.Tombstone{
+	SnippetID:  6,
+	UserID:     1,
+	Slug:       "Pq7Rt2Vw9Xy4Za1Bc6De3F",
+	Visibility: models.Public,
+	Expired:    time.Date(2020, 3, 1, 9, 30, 0, 0, time.UTC),
+}
+
+// ReapExpired is a mock handler for removing expired snippets
+func (m *SnippetModel) ReapExpired(limit int) (int, error) {
+	return 0, nil
+}
+
+// Tombstone is a mock handler for retrieving the record of an expired snippet
+func (m *SnippetModel) Tombstone(id int) (*models.Tombstone, error) {
+	if id == mockTombstone.SnippetID {
+		return mockTombstone, nil
+	}
+	return nil, models.ErrNoRecord
+}
+
+// TombstoneBySlug is a mock handler for retrieving the record of an expired
+// snippet by its slug
+func (m *SnippetModel) TombstoneBySlug(slug string) (*models.Tombstone, error) {
+	if slug == mockTombstone.Slug {
+		return mockTombstone, nil
+	}
+	return nil, models.ErrNoRecord
 }
@@ -0,0 +1,20 @@
+package mock
+
+// StarModel mocks the star model
+type StarModel struct{}
+
+// Add mocks starring a snippet
+func (m *StarModel) Add(userID, snippetID int) error {
+	return nil
+}
+
+// Remove mocks unstarring a snippet
+func (m *StarModel) Remove(userID, snippetID int) error {
+	return nil
+}
+
+// Has mocks checking for a star.  Alice (user 1) has starred the first
+// snippet and the fork of it.
+func (m *StarModel) Has(userID, snippetID int) (bool, error) {
+	return userID == 1 && (snippetID == 1 || snippetID == 7), nil
+}
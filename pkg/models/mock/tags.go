@@ -0,0 +1,19 @@
+package mock
+
+// TagModel mocks the tag model
+type TagModel struct{}
+
+// Set mocks replacing a snippet's tags
+func (m *TagModel) Set(snippetID int, tags []string) error {
+	return nil
+}
+
+// ForSnippet mocks listing a snippet's tags
+func (m *TagModel) ForSnippet(snippetID int) ([]string, error) {
+	switch snippetID {
+	case 1:
+		return []string{"haiku", "poetry"}, nil
+	default:
+		return []string{}, nil
+	}
+}
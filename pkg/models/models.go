@@ -2,6 +2,9 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,15 +13,193 @@ var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrInvalidCursor      = errors.New("models: invalid cursor")
 )
 
 // Snippet defines the model for the Snippet table
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
+	ID         int
+	UserID     int
+	ParentID   int // the snippet this was forked from, zero for none
+	Title      string
+	Filename   string // of the first file, which may be blank
+	Content    string // of the first file, when loaded
+	Language   string // of the first file
+	Visibility string
+	Slug       string
+	MaxViews   int // zero for no limit
+	Views      int
+	Stars      int // the number of users who have starred it
+	Revision   int
+	Created    time.Time
+	Expires    time.Time
+	Deleted    time.Time
+	Tags       []string
+	Files      []*File // every file including the first, when loaded
+}
+
+// File defines the model for one of the files of a snippet.  The first file
+// of every snippet is kept in the snippets table, and any others in the
+// snippet_files table.
+type File struct {
+	Name     string
+	Language string
+	Content  string
+}
+
+// ViewsLeft returns how many more times a view-limited snippet may be viewed
+func (s *Snippet) ViewsLeft() int {
+	if s.Views >= s.MaxViews {
+		return 0
+	}
+	return s.MaxViews - s.Views
+}
+
+// Attachment defines the model for the snippet_attachments table, which
+// describes files uploaded alongside a snippet.  Their bytes are kept in a
+// blob store under Key.
+type Attachment struct {
+	ID          int
+	SnippetID   int
+	UserID      int
+	Name        string
+	ContentType string // as detected from the content, not as uploaded
+	Size        int64
+	Key         string
+	Created     time.Time
+}
+
+// Comment defines the model for the comments table.  A comment may be
+// anchored to a range of lines of one of a snippet's files as they were in a
+// particular revision, or may reply to another comment which is not itself a
+// reply.
+type Comment struct {
+	ID        int
+	SnippetID int
+	UserID    int
+	Author    string // the name of the user, when loaded
+	ParentID  int    // the comment replied to, zero for none
+	Revision  int    // of the snippet when the comment was posted
+	File      int    // the position of the file anchored to
+	FirstLine int    // zero when not anchored to any lines
+	LastLine  int
+	Body      string
+	Created   time.Time
+	Replies   []*Comment // oldest first, when loaded
+}
+
+// Snippet visibilities.  Public snippets are listed and reachable by ID,
+// unlisted ones only through their unguessable slug, and private ones only by
+// their owner.
+const (
+	Public   = "public"
+	Unlisted = "unlisted"
+	Private  = "private"
+)
+
+// Visibilities lists the snippet visibilities in the order they should be
+// offered to users
+var Visibilities = []string{Public, Unlisted, Private}
+
+// Collection defines the model for the collections table, a named group of
+// snippets put together by a user.  A snippet may be in any number of
+// collections.  Collections are either private to their owner or unlisted,
+// shared through their slug like unlisted snippets.
+type Collection struct {
+	ID         int
+	UserID     int
+	Name       string
+	Visibility string
+	Slug       string
+	Created    time.Time
+	Size       int // the number of snippets in it, when loaded
+}
+
+// CollectionVisibilities lists the visibilities a collection may have in the
+// order they should be offered to users
+var CollectionVisibilities = []string{Private, Unlisted}
+
+// Tombstone defines the model for the snippet_tombstones table which records
+// snippets removed after expiring, so requests for them can be told they have
+// gone rather than that they never existed
+type Tombstone struct {
+	SnippetID  int
+	UserID     int
+	Slug       string
+	Visibility string
+	Expired    time.Time
+}
+
+// Revision defines the model for the snippet_revisions table which records
+// the title and files of a snippet each time it is created or edited.  Files
+// are only retrieved with a single revision.
+type Revision struct {
+	SnippetID int
+	Number    int
+	UserID    int
+	Title     string
+	Files     []*File
+	Created   time.Time
+}
+
+// SearchQuery defines the criteria for a full-text search of snippets.  Zero
+// values of the optional fields mean the criterion is not applied.  ViewerID
+// is the user searching, if any; restricting the search to their own
+// snippets with UserID finds those which are not public too.
+type SearchQuery struct {
+	Terms         string
+	UserID        int
+	ViewerID      int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Offset        int
+	Limit         int
+}
+
+// Cursor marks a position in a listing of snippets ordered by creation time.
+// The ID breaks ties between snippets created in the same second.
+type Cursor struct {
 	Created time.Time
-	Expires time.Time
+	ID      int
+}
+
+// cursorLayout is the timestamp format used when encoding a cursor
+const cursorLayout = "20060102150405"
+
+// CursorFor returns a cursor positioned at the given snippet
+func CursorFor(s *Snippet) *Cursor {
+	return &Cursor{Created: s.Created, ID: s.ID}
+}
+
+// String encodes the cursor in a compact URL-safe form
+func (c *Cursor) String() string {
+	return fmt.Sprintf("%s-%d", c.Created.UTC().Format(cursorLayout), c.ID)
+}
+
+// ParseCursor decodes a cursor previously encoded by Cursor.String()
+func ParseCursor(s string) (*Cursor, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	created, err := time.Parse(cursorLayout, parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || id < 1 {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{Created: created, ID: id}, nil
+}
+
+// Page identifies one page of a listing ordered newest first.  Before selects
+// the snippets older than a cursor and After those newer than it; at most one
+// of them should be set.  When neither is the newest snippets are returned.
+type Page struct {
+	Before *Cursor
+	After  *Cursor
+	Limit  int
 }
 
 // User defines the model for the users table
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+
+	c := &Cursor{Created: time.Date(2020, 12, 17, 10, 0, 1, 0, time.UTC), ID: 42}
+	if s := c.String(); s != "20201217100001-42" {
+		t.Errorf("want %q; got %q", "20201217100001-42", s)
+	}
+
+	got, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("want %v; got %v", c, got)
+	}
+
+	for _, s := range []string{"", "20201217100001", "20201217100001-", "2020-42", "20201217100001-0", "20201217100001-x"} {
+		if _, err := ParseCursor(s); err != ErrInvalidCursor {
+			t.Errorf("%q: want %v; got %v", s, ErrInvalidCursor, err)
+		}
+	}
+}
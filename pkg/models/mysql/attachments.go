@@ -0,0 +1,132 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+// AttachmentModel wraps a sql.DB connection pool
+type AttachmentModel struct {
+	DB *sql.DB
+}
+
+// attachmentColumns lists the columns selected by attachment queries in the
+// order expected by attachmentFields
+const attachmentColumns = `id, snippet_id, user_id, name, content_type, size, blob_key, created`
+
+// attachmentFields returns the destinations for scanning attachmentColumns
+// into an attachment
+func attachmentFields(a *models.Attachment) []interface{} {
+	return []interface{}{&a.ID, &a.SnippetID, &a.UserID, &a.Name, &a.ContentType, &a.Size, &a.Key, &a.Created}
+}
+
+// Insert records an attachment uploaded by a user to a snippet whose bytes
+// have been stored under the key
+func (m *AttachmentModel) Insert(snippetID, userID int, name, contentType, key string, size int64) (int, error) {
+
+	stmt := `INSERT INTO snippet_attachments (snippet_id, user_id, name, content_type, size, blob_key, created)
+				VALUES (?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, snippetID, userID, name, contentType, size, key)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get a specific attachment based on its id
+func (m *AttachmentModel) Get(id int) (*models.Attachment, error) {
+
+	stmt := `SELECT ` + attachmentColumns + `
+				FROM snippet_attachments
+				WHERE id = ?`
+
+	a := &models.Attachment{}
+	err := m.DB.QueryRow(stmt, id).Scan(attachmentFields(a)...)
+	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
+		return nil, models.ErrNoRecord
+	}
+	if err != nil { // All other errors
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// ForSnippet returns the attachments of a snippet in the order they were
+// uploaded
+func (m *AttachmentModel) ForSnippet(snippetID int) ([]*models.Attachment, error) {
+
+	stmt := `SELECT ` + attachmentColumns + `
+				FROM snippet_attachments
+				WHERE snippet_id = ?
+				ORDER BY id`
+
+	return m.query(stmt, snippetID)
+}
+
+// Usage returns the total size of the attachments a user has uploaded to
+// snippets which still exist, including those in the trash
+func (m *AttachmentModel) Usage(userID int) (int64, error) {
+
+	stmt := `SELECT COALESCE(SUM(a.size), 0)
+				FROM snippet_attachments a
+				INNER JOIN snippets s ON s.id = a.snippet_id
+				WHERE a.user_id = ?`
+
+	var usage int64
+	err := m.DB.QueryRow(stmt, userID).Scan(&usage)
+	return usage, err
+}
+
+// Orphans returns up to limit attachments left behind by snippets which have
+// been removed, whose blobs are ready to be deleted
+func (m *AttachmentModel) Orphans(limit int) ([]*models.Attachment, error) {
+
+	stmt := `SELECT ` + attachmentColumns + `
+				FROM snippet_attachments
+				WHERE snippet_id NOT IN (SELECT id FROM snippets)
+				ORDER BY id
+				LIMIT ?`
+
+	return m.query(stmt, limit)
+}
+
+// Delete removes the record of an attachment.  Its blob should be deleted
+// first so that it is never left without a record.
+func (m *AttachmentModel) Delete(id int) error {
+	return execOne(m.DB, `DELETE FROM snippet_attachments WHERE id = ?`, id)
+}
+
+// query runs an attachments query and copies each row of the result into a
+// slice of attachment models
+func (m *AttachmentModel) query(stmt string, args ...interface{}) ([]*models.Attachment, error) {
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []*models.Attachment{}
+	for rows.Next() {
+		a := &models.Attachment{}
+		if err = rows.Scan(attachmentFields(a)...); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,198 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+// CollectionModel wraps a sql.DB connection pool
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// collectionColumns lists the columns selected by collection queries in the
+// order expected by collectionFields
+const collectionColumns = `id, user_id, name, visibility, slug, created`
+
+// collectionFields returns the destinations for scanning collectionColumns
+// into a collection
+func collectionFields(c *models.Collection) []interface{} {
+	return []interface{}{&c.ID, &c.UserID, &c.Name, &c.Visibility, &c.Slug, &c.Created}
+}
+
+// Insert creates an empty collection owned by the given user, returning its
+// ID
+func (m *CollectionModel) Insert(userID int, name, visibility string) (int, error) {
+
+	slug, err := newSlug()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO collections (user_id, name, visibility, slug, created)
+				VALUES (?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, userID, name, visibility, slug)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get a specific collection based on its id
+func (m *CollectionModel) Get(id int) (*models.Collection, error) {
+	return m.get(`id = ?`, id)
+}
+
+// BySlug gets a specific collection based on its slug
+func (m *CollectionModel) BySlug(slug string) (*models.Collection, error) {
+	return m.get(`slug = ?`, slug)
+}
+
+// get returns the collection meeting a condition on a unique column
+func (m *CollectionModel) get(where string, value interface{}) (*models.Collection, error) {
+
+	stmt := `SELECT ` + collectionColumns + `
+				FROM collections
+				WHERE ` + where
+
+	c := &models.Collection{}
+	err := m.DB.QueryRow(stmt, value).Scan(collectionFields(c)...)
+	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
+		return nil, models.ErrNoRecord
+	}
+	if err != nil { // All other errors
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ByUser returns the collections owned by a specific user in alphabetical
+// order, each with the number of unexpired snippets in it
+func (m *CollectionModel) ByUser(userID int) ([]*models.Collection, error) {
+
+	stmt := `SELECT c.id, c.user_id, c.name, c.visibility, c.slug, c.created, COUNT(s.id)
+				FROM collections c
+				LEFT JOIN collection_snippets cs ON cs.collection_id = c.id
+				LEFT JOIN snippets s ON s.id = cs.snippet_id AND ` + liveRevision + `
+				WHERE c.user_id = ?
+				GROUP BY c.id
+				ORDER BY c.name, c.id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []*models.Collection{}
+	for rows.Next() {
+		c := &models.Collection{}
+		if err = rows.Scan(append(collectionFields(c), &c.Size)...); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// ForSnippet returns the collections owned by a specific user which a
+// snippet is in, in alphabetical order
+func (m *CollectionModel) ForSnippet(snippetID, userID int) ([]*models.Collection, error) {
+
+	stmt := `SELECT ` + collectionColumns + `
+				FROM collections
+				WHERE user_id = ? AND id IN (
+					SELECT collection_id FROM collection_snippets WHERE snippet_id = ?
+				)
+				ORDER BY name, id`
+
+	rows, err := m.DB.Query(stmt, userID, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []*models.Collection{}
+	for rows.Next() {
+		c := &models.Collection{}
+		if err = rows.Scan(collectionFields(c)...); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// Update renames a collection owned by the given user and sets its
+// visibility
+func (m *CollectionModel) Update(id, userID int, name, visibility string) error {
+
+	stmt := `UPDATE collections SET name = ?, visibility = ? WHERE id = ? AND user_id = ?`
+
+	_, err := m.DB.Exec(stmt, name, visibility, id, userID)
+	return err
+}
+
+// Delete removes a collection owned by the given user.  The snippets in it
+// are left alone.
+func (m *CollectionModel) Delete(id, userID int) error {
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The collection's snippets are forgotten first while the collection
+	// can still be used to confirm ownership
+	stmt := `DELETE cs FROM collection_snippets cs
+				INNER JOIN collections c ON c.id = cs.collection_id
+				WHERE c.id = ? AND c.user_id = ?`
+	if _, err = tx.Exec(stmt, id, userID); err != nil {
+		return err
+	}
+
+	stmt = `DELETE FROM collections WHERE id = ? AND user_id = ?`
+	if err = execOne(tx, stmt, id, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Add puts a snippet into a collection, doing nothing if it is already there
+func (m *CollectionModel) Add(collectionID, snippetID int) error {
+
+	stmt := `INSERT IGNORE INTO collection_snippets (collection_id, snippet_id, added)
+				VALUES (?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, collectionID, snippetID)
+	return err
+}
+
+// Remove takes a snippet out of a collection, doing nothing if it is not there
+func (m *CollectionModel) Remove(collectionID, snippetID int) error {
+
+	stmt := `DELETE FROM collection_snippets WHERE collection_id = ? AND snippet_id = ?`
+
+	_, err := m.DB.Exec(stmt, collectionID, snippetID)
+	return err
+}
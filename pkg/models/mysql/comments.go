@@ -0,0 +1,113 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+// CommentModel wraps a sql.DB connection pool
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// commentColumns lists the columns selected by comment queries joining users
+// as u in the order expected by commentFields
+const commentColumns = `c.id, c.snippet_id, c.user_id, u.name, c.parent_id, c.revision, c.position, c.first_line, c.last_line, c.body, c.created`
+
+// commentFields returns the destinations for scanning commentColumns into a
+// comment
+func commentFields(c *models.Comment) []interface{} {
+	return []interface{}{&c.ID, &c.SnippetID, &c.UserID, &c.Author, nullInt{&c.ParentID}, &c.Revision, &c.File, &c.FirstLine, &c.LastLine, &c.Body, &c.Created}
+}
+
+// Insert adds a comment to a snippet, returning its ID.  The caller is
+// responsible for checking that any comment replied to belongs to the same
+// snippet and is not itself a reply.
+func (m *CommentModel) Insert(c *models.Comment) (int, error) {
+
+	var parent interface{}
+	if c.ParentID != 0 {
+		parent = c.ParentID
+	}
+
+	stmt := `INSERT INTO comments (snippet_id, user_id, parent_id, revision, position, first_line, last_line, body, created)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, c.SnippetID, c.UserID, parent, c.Revision, c.File, c.FirstLine, c.LastLine, c.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get a specific comment based on its id
+func (m *CommentModel) Get(id int) (*models.Comment, error) {
+
+	stmt := `SELECT ` + commentColumns + `
+				FROM comments c
+				INNER JOIN users u ON u.id = c.user_id
+				WHERE c.id = ?`
+
+	c := &models.Comment{}
+	err := m.DB.QueryRow(stmt, id).Scan(commentFields(c)...)
+	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
+		return nil, models.ErrNoRecord
+	}
+	if err != nil { // All other errors
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ForSnippet returns the comments on a snippet which are not replies, oldest
+// first, each with its replies
+func (m *CommentModel) ForSnippet(snippetID int) ([]*models.Comment, error) {
+
+	stmt := `SELECT ` + commentColumns + `
+				FROM comments c
+				INNER JOIN users u ON u.id = c.user_id
+				WHERE c.snippet_id = ?
+				ORDER BY c.created, c.id`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Replies always come after the comment they reply to, which has been
+	// seen by the time they are
+	comments := []*models.Comment{}
+	byID := map[int]*models.Comment{}
+	for rows.Next() {
+		c := &models.Comment{}
+		if err = rows.Scan(commentFields(c)...); err != nil {
+			return nil, err
+		}
+		if c.ParentID == 0 {
+			comments = append(comments, c)
+			byID[c.ID] = c
+		} else if parent, ok := byID[c.ParentID]; ok {
+			parent.Replies = append(parent.Replies, c)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Delete removes a comment along with any replies to it
+func (m *CommentModel) Delete(id int) error {
+	return execOne(m.DB, `DELETE FROM comments WHERE id = ? OR parent_id = ?`, id, id)
+}
@@ -0,0 +1,233 @@
+package mysql
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"ptodd.org/snippetbox/pkg/keyring"
+)
+
+// Content is stored once however many snippets, files and revisions have it,
+// in the contents table keyed by its SHA-256 hash.  Each row counts the
+// references to it so it can be removed along with the last of them.
+//
+// Content of at least compressThreshold bytes is compressed into the packed
+// column, leaving only its first searchableSize bytes in the body column so
+// the full-text index still covers its start.  Otherwise the body column
+// holds all of it and packed is NULL.
+//
+// Given a keyring, content is instead encrypted: it is always compressed, and
+// packed holds it sealed under a data key kept wrapped in the data_key
+// column, with the ID of the master key wrapping it in key_id.  The body
+// column is left empty, so encrypted content cannot be searched.  It is
+// keyed by an HMAC under the keyring's hash key rather than a plain hash,
+// which anyone with a copy of the database could check guesses against, and
+// its key is authenticated with it so rows cannot be swapped.  Unencrypted
+// rows have a NULL key_id.
+
+// compressThreshold is the size in bytes from which content is compressed
+const compressThreshold = 8 << 10
+
+// searchableSize is the most bytes of compressed content kept uncompressed
+// for searching
+const searchableSize = 8 << 10
+
+// contentHash returns the key of some content in the contents table, keyed
+// by the keyring if one is given
+func contentHash(keys *keyring.Keyring, content string) []byte {
+	if keys != nil {
+		return keys.Hash([]byte(content))
+	}
+	h := sha256.Sum256([]byte(content))
+	return h[:]
+}
+
+// addContent stores some content if it is not already stored and counts a
+// new reference to it as part of a wider transaction, returning its hash.
+// The content is encrypted if a keyring is given.
+func addContent(tx *sql.Tx, keys *keyring.Keyring, content string) ([]byte, error) {
+	c, err := newStoredContent(keys, content)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `INSERT INTO contents (hash, body, packed, key_id, data_key, refs) VALUES (?, ?, ?, ?, ?, 1)
+				ON DUPLICATE KEY UPDATE refs = refs + 1`
+	_, err = tx.Exec(stmt, c.hash, string(c.body), c.packed, c.keyID, c.dataKey)
+	return c.hash, err
+}
+
+// addRefs adds delta to the references to content held by a snippet, one
+// for each of its files, as part of a wider transaction
+func addRefs(tx *sql.Tx, id, delta int) error {
+	stmt := `UPDATE contents c
+				INNER JOIN (SELECT hash, COUNT(*) AS n FROM (
+					SELECT content_hash AS hash FROM snippets WHERE id = ?
+					UNION ALL
+					SELECT content_hash FROM snippet_files WHERE snippet_id = ?) x GROUP BY hash) d ON d.hash = c.hash
+				SET c.refs = c.refs + ? * d.n`
+	_, err := tx.Exec(stmt, id, id, delta)
+	return err
+}
+
+// releaseContent drops the references to content held by the snippets
+// meeting a condition on snippets as s and by their files and revisions,
+// removing any content no longer referenced.  It must be called before those
+// rows are deleted as part of the same transaction.
+func releaseContent(tx *sql.Tx, where string, args ...interface{}) error {
+	refs := `SELECT s.content_hash AS hash FROM snippets s WHERE ` + where + `
+				UNION ALL
+				SELECT f.content_hash FROM snippet_files f
+				INNER JOIN snippets s ON s.id = f.snippet_id
+				WHERE ` + where + `
+				UNION ALL
+				SELECT r.content_hash FROM snippet_revisions r
+				INNER JOIN snippets s ON s.id = r.snippet_id
+				WHERE ` + where + `
+				UNION ALL
+				SELECT r.content_hash FROM snippet_revision_files r
+				INNER JOIN snippets s ON s.id = r.snippet_id
+				WHERE ` + where
+	all := make([]interface{}, 0, 4*len(args))
+	for i := 0; i < 4; i++ {
+		all = append(all, args...)
+	}
+
+	stmt := `UPDATE contents c
+				INNER JOIN (SELECT hash, COUNT(*) AS n FROM (` + refs + `) x GROUP BY hash) d ON d.hash = c.hash
+				SET c.refs = c.refs - d.n`
+	if _, err := tx.Exec(stmt, all...); err != nil {
+		return err
+	}
+
+	stmt = `DELETE c FROM contents c
+				INNER JOIN (SELECT DISTINCT hash FROM (` + refs + `) x) d ON d.hash = c.hash
+				WHERE c.refs <= 0`
+	_, err := tx.Exec(stmt, all...)
+	return err
+}
+
+// compress returns content compressed for the packed column
+func compress(content string) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(fw, content); err != nil {
+		return nil, err
+	}
+	if err = fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// searchable returns the start of some content kept in the body column when
+// it is compressed, cut at a character boundary
+func searchable(content string) string {
+	if len(content) <= searchableSize {
+		return content
+	}
+	n := searchableSize
+	for n > 0 && !utf8.RuneStart(content[n]) {
+		n--
+	}
+	return content[:n]
+}
+
+// errNoKeyring is returned on reading encrypted content without a keyring
+var errNoKeyring = errors.New("models: content is encrypted but no keyring is configured")
+
+// contentColumns lists the columns of contents as c in the order expected by
+// storedContent.fields
+const contentColumns = `c.hash, c.body, c.packed, c.key_id, c.data_key`
+
+// storedContent holds a row of contents as it is stored
+type storedContent struct {
+	hash    []byte
+	body    []byte
+	packed  []byte
+	keyID   sql.NullString
+	dataKey []byte
+}
+
+// newStoredContent returns some content as it is to be stored, encrypting it
+// if a keyring is given
+func newStoredContent(keys *keyring.Keyring, content string) (*storedContent, error) {
+	c := &storedContent{hash: contentHash(keys, content), body: []byte(content)}
+	if keys == nil && len(content) < compressThreshold {
+		return c, nil
+	}
+
+	packed, err := compress(content)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		c.body, c.packed = []byte(searchable(content)), packed
+		return c, nil
+	}
+
+	keyID, dataKey, sealed, err := keys.Seal(packed, c.hash)
+	if err != nil {
+		return nil, err
+	}
+	c.body, c.packed = []byte{}, sealed
+	c.keyID = sql.NullString{String: keyID, Valid: true}
+	c.dataKey = dataKey
+	return c, nil
+}
+
+// fields returns the destinations for scanning contentColumns
+func (c *storedContent) fields() []interface{} {
+	return []interface{}{&c.hash, &c.body, &c.packed, &c.keyID, &c.dataKey}
+}
+
+// compressed returns the compressed content, decrypting it if need be, or
+// nil if it is not compressed
+func (c *storedContent) compressed(keys *keyring.Keyring) ([]byte, error) {
+	if !c.keyID.Valid {
+		return c.packed, nil
+	}
+	if keys == nil {
+		return nil, errNoKeyring
+	}
+	return keys.Open(c.keyID.String, c.dataKey, c.packed, c.hash)
+}
+
+// text returns the whole content, decrypting and decompressing it if need be
+func (c *storedContent) text(keys *keyring.Keyring) (string, error) {
+	packed, err := c.compressed(keys)
+	if err != nil {
+		return "", err
+	}
+	if packed == nil {
+		return string(c.body), nil
+	}
+	b, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(packed)))
+	return string(b), err
+}
+
+// writeTo writes the whole content to w, decrypting it in memory and then
+// decompressing it as it goes if need be
+func (c *storedContent) writeTo(w io.Writer, keys *keyring.Keyring) error {
+	packed, err := c.compressed(keys)
+	if err != nil {
+		return err
+	}
+	if packed == nil {
+		_, err = w.Write(c.body)
+		return err
+	}
+	fr := flate.NewReader(bytes.NewReader(packed))
+	defer fr.Close()
+	_, err = io.Copy(w, fr)
+	return err
+}
@@ -0,0 +1,101 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"ptodd.org/snippetbox/pkg/keyring"
+)
+
+func TestStoredContent(t *testing.T) {
+
+	keys, err := keyring.Parse(strings.NewReader("hash QEFCQ0RFRkdISUpLTE1OT1BRUlNUVVZXWFlaW1xdXl8=\n" +
+		"test AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		content    string
+		wantPacked bool
+	}{
+		{"Empty", "", false},
+		{"Small", "An old silent pond...", false},
+		{"Just below the threshold", strings.Repeat("a", compressThreshold-1), false},
+		{"Large", strings.Repeat("A frog jumps into the pond, splash! ", 1000), true},
+		{"Large multi-byte", strings.Repeat("古池や蛙飛び込む水の音", 1000), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			c, err := newStoredContent(nil, tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain := sha256.Sum256([]byte(tt.content))
+			if !bytes.Equal(c.hash, plain[:]) {
+				t.Errorf("want the SHA-256 hash of the content")
+			}
+			if got := c.packed != nil; got != tt.wantPacked {
+				t.Fatalf("want packed %t; got %t", tt.wantPacked, got)
+			}
+			if c.packed != nil && len(c.packed) >= len(tt.content) {
+				t.Errorf("want compressed content smaller than %d bytes; got %d", len(tt.content), len(c.packed))
+			}
+			if len(c.body) > searchableSize || !utf8.Valid(c.body) || !strings.HasPrefix(tt.content, string(c.body)) {
+				t.Errorf("want body to be a valid start of the content of at most %d bytes", searchableSize)
+			}
+			checkContent(t, c, nil, tt.content)
+
+			// Encrypted, nothing of it is left in the clear, not even a
+			// hash to check guesses against, and it cannot be read without
+			// the keyring
+			e, err := newStoredContent(keys, tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(e.hash, plain[:]) || !bytes.Equal(e.hash, keys.Hash([]byte(tt.content))) {
+				t.Errorf("want the keyed hash of the content")
+			}
+			if len(e.body) != 0 || !e.keyID.Valid || e.dataKey == nil {
+				t.Errorf("want only ciphertext stored")
+			}
+			if _, err = e.text(nil); !errors.Is(err, errNoKeyring) {
+				t.Errorf("want %v; got %v", errNoKeyring, err)
+			}
+			checkContent(t, e, keys, tt.content)
+
+			// Nor can it be read under another hash
+			e.hash = contentHash(keys, "A different pond")
+			if _, err = e.text(keys); !errors.Is(err, keyring.ErrDecrypt) {
+				t.Errorf("want %v; got %v", keyring.ErrDecrypt, err)
+			}
+		})
+	}
+}
+
+// checkContent checks both ways of reading stored content give the original
+func checkContent(t *testing.T, c *storedContent, keys *keyring.Keyring, want string) {
+	t.Helper()
+
+	got, err := c.text(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("want text of %d bytes; got %d", len(want), len(got))
+	}
+	var buf bytes.Buffer
+	if err = c.writeTo(&buf, keys); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("want %d bytes written; got %d", len(want), buf.Len())
+	}
+}
@@ -1,26 +1,122 @@
 package mysql
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
 
+	"ptodd.org/snippetbox/pkg/keyring"
 	"ptodd.org/snippetbox/pkg/models"
 )
 
-// SnippetModel wrapps a sql.DB connection pool
+// SnippetModel wrapps a sql.DB connection pool.  Given a keyring, it
+// encrypts the content it stores with it; without one, content is stored in
+// plaintext and encrypted content cannot be read.
 type SnippetModel struct {
-	DB *sql.DB
+	DB   *sql.DB
+	Keys *keyring.Keyring
 }
 
-// Insert a new snippet into the database
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+// snippetColumns lists the columns selected by snippet queries in the order
+// expected by snippetFields
+const snippetColumns = `id, user_id, parent_id, title, filename, language, visibility, slug, max_views, views, stars, revision, created, expires`
+
+// snippetFields returns the destinations for scanning snippetColumns into a
+// snippet
+func snippetFields(s *models.Snippet) []interface{} {
+	return []interface{}{&s.ID, &s.UserID, nullInt{&s.ParentID}, &s.Title, &s.Filename, &s.Language, &s.Visibility, &s.Slug, &s.MaxViews, &s.Views, &s.Stars, &s.Revision, &s.Created, nullTime{&s.Expires}}
+}
+
+// live is the condition met by snippets which have neither expired nor been
+// deleted.  Snippets which never expire have a NULL expiry time.
+const live = `(expires IS NULL OR expires > UTC_TIMESTAMP()) AND deleted_at IS NULL`
+
+// liveRevision is the equivalent of live for queries joining snippets as s
+const liveRevision = `(s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL`
+
+// nullTime scans a nullable DATETIME column into a time.Time, leaving the
+// zero time for NULL
+type nullTime struct {
+	t *time.Time
+}
+
+// Scan implements the sql.Scanner interface
+func (n nullTime) Scan(value interface{}) error {
+	var nt sql.NullTime
+	if err := nt.Scan(value); err != nil {
+		return err
+	}
+	*n.t = nt.Time
+	return nil
+}
+
+// nullInt scans a nullable INTEGER column into an int, leaving zero for NULL
+type nullInt struct {
+	i *int
+}
+
+// Scan implements the sql.Scanner interface
+func (n nullInt) Scan(value interface{}) error {
+	var ni sql.NullInt64
+	if err := ni.Scan(value); err != nil {
+		return err
+	}
+	*n.i = int(ni.Int64)
+	return nil
+}
+
+// newSlug returns a random URL-safe identifier for a snippet.  Its 128 bits
+// make it infeasible to guess, unlike the sequential IDs.
+func newSlug() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Insert a new snippet owned by the given user into the database along with
+// its files and first revision.  There must be at least one file.  A snippet
+// with a zero expiry time never expires, and one with a non-zero maxViews is
+// deleted once it has been viewed that many times.  A non-zero parentID
+// records the snippet as a fork of that one.
+func (m *SnippetModel) Insert(userID int, title string, files []*models.File, visibility string, expires time.Time, maxViews, parentID int) (int, error) {
+
+	if len(files) == 0 {
+		return 0, errors.New("models: a snippet must have at least one file")
+	}
+
+	slug, err := newSlug()
+	if err != nil {
+		return 0, err
+	}
+
+	// Every row is written in a single transaction so a snippet never exists
+	// without its files and history
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	hash, err := addContent(tx, m.Keys, files[0].Content)
+	if err != nil {
+		return 0, err
+	}
 
 	// Insert SQL to add a row into the snippets table
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	        	VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+	stmt := `INSERT INTO snippets (user_id, parent_id, title, filename, content_hash, language, visibility, slug, max_views, created, expires)
+	        	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), ?)`
 
 	// Execute the insert
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	parent := sql.NullInt64{Int64: int64(parentID), Valid: parentID != 0}
+	exp := sql.NullTime{Time: expires.UTC(), Valid: !expires.IsZero()}
+	result, err := tx.Exec(stmt, userID, parent, title, files[0].Name, hash, files[0].Language, visibility, slug, maxViews, exp)
 	if err != nil {
 		return 0, err
 	}
@@ -31,22 +127,275 @@ func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
 		return 0, err
 	}
 
+	if err = insertFiles(tx, m.Keys, int(id), files[1:]); err != nil {
+		return 0, err
+	}
+
+	// Record the snippet as it was created as its first revision
+	if err = insertRevision(tx, int(id), userID); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
 	return int(id), nil
 }
 
-// Get a specific snippet based on its id
+// Update replaces the title, files and visibility of an unexpired snippet
+// and records the result as a new revision made by the given user.  There
+// must be at least one file.
+func (m *SnippetModel) Update(id, userID int, title string, files []*models.File, visibility string) error {
+
+	if len(files) == 0 {
+		return errors.New("models: a snippet must have at least one file")
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The row lock taken here serializes concurrent edits of the same
+	// snippet
+	stmt := `SELECT id FROM snippets WHERE ` + live + ` AND id = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, id).Scan(&id)
+	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
+		return models.ErrNoRecord
+	}
+	if err != nil { // All other errors
+		return err
+	}
+
+	// The snippet's references move from the content of its old files to
+	// that of the new, which may be the same; the old revisions keep theirs
+	if err = addRefs(tx, id, -1); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM snippet_files WHERE snippet_id = ?`, id); err != nil {
+		return err
+	}
+	hash, err := addContent(tx, m.Keys, files[0].Content)
+	if err != nil {
+		return err
+	}
+
+	// Update SQL to change the snippet and bump its revision number
+	stmt = `UPDATE snippets SET title = ?, filename = ?, content_hash = ?, language = ?, visibility = ?, revision = revision + 1
+				WHERE id = ?`
+
+	_, err = tx.Exec(stmt, title, files[0].Name, hash, files[0].Language, visibility, id)
+	if err != nil {
+		return err
+	}
+	if err = insertFiles(tx, m.Keys, id, files[1:]); err != nil {
+		return err
+	}
+
+	if err = insertRevision(tx, id, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertFiles adds the files of a snippet after the first, which is held in
+// the snippet itself, as part of a wider transaction.  They are numbered from
+// one.
+func insertFiles(tx *sql.Tx, keys *keyring.Keyring, id int, files []*models.File) error {
+	stmt := `INSERT INTO snippet_files (snippet_id, position, name, language, content_hash)
+				VALUES (?, ?, ?, ?, ?)`
+	for i, f := range files {
+		hash, err := addContent(tx, keys, f.Content)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(stmt, id, i+1, f.Name, f.Language, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertRevision copies the current state of a snippet and its files into
+// the snippet_revisions and snippet_revision_files tables as part of a wider
+// transaction, the revision sharing the snippet's content
+func insertRevision(tx *sql.Tx, id, userID int) error {
+	stmt := `INSERT INTO snippet_revisions (snippet_id, revision, user_id, title, filename, language, content_hash, created)
+				SELECT id, revision, ?, title, filename, language, content_hash, UTC_TIMESTAMP()
+				FROM snippets
+				WHERE id = ?`
+	if _, err := tx.Exec(stmt, userID, id); err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO snippet_revision_files (snippet_id, revision, position, name, language, content_hash)
+				SELECT f.snippet_id, s.revision, f.position, f.name, f.language, f.content_hash
+				FROM snippet_files f
+				INNER JOIN snippets s ON s.id = f.snippet_id
+				WHERE f.snippet_id = ?`
+	if _, err := tx.Exec(stmt, id); err != nil {
+		return err
+	}
+
+	return addRefs(tx, id, 1)
+}
+
+// Revisions returns the history of an unexpired snippet with the most recent
+// revision first.  Content is omitted as history listings do not show it.
+func (m *SnippetModel) Revisions(id int) ([]*models.Revision, error) {
+
+	stmt := `SELECT r.snippet_id, r.revision, r.user_id, r.title, r.created
+				FROM snippet_revisions r
+				INNER JOIN snippets s ON s.id = r.snippet_id
+				WHERE ` + liveRevision + ` AND r.snippet_id = ?
+				ORDER BY r.revision DESC`
+
+	rows, err := m.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*models.Revision{}
+	for rows.Next() {
+		r := &models.Revision{}
+		err = rows.Scan(&r.SnippetID, &r.Number, &r.UserID, &r.Title, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Revision returns a specific revision of an unexpired snippet along with
+// its files
+func (m *SnippetModel) Revision(id, number int) (*models.Revision, error) {
+
+	stmt := `SELECT r.snippet_id, r.revision, r.user_id, r.title, r.created, r.filename, r.language, ` + contentColumns + `
+				FROM snippet_revisions r
+				INNER JOIN contents c ON c.hash = r.content_hash
+				INNER JOIN snippets s ON s.id = r.snippet_id
+				WHERE ` + liveRevision + ` AND r.snippet_id = ? AND r.revision = ?`
+
+	r := &models.Revision{}
+	f := &models.File{}
+	c := &storedContent{}
+	err := m.DB.QueryRow(stmt, id, number).Scan(append([]interface{}{&r.SnippetID, &r.Number, &r.UserID, &r.Title, &r.Created, &f.Name, &f.Language}, c.fields()...)...)
+	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
+		return nil, models.ErrNoRecord
+	}
+	if err != nil { // All other errors
+		return nil, err
+	}
+	if f.Content, err = c.text(m.Keys); err != nil {
+		return nil, err
+	}
+	r.Files = []*models.File{f}
+
+	// Any further files follow in order
+	stmt = `SELECT f.name, f.language, ` + contentColumns + `
+				FROM snippet_revision_files f
+				INNER JOIN contents c ON c.hash = f.content_hash
+				WHERE f.snippet_id = ? AND f.revision = ?
+				ORDER BY f.position`
+
+	rows, err := m.DB.Query(stmt, id, number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f := &models.File{}
+		c := &storedContent{}
+		if err = rows.Scan(append([]interface{}{&f.Name, &f.Language}, c.fields()...)...); err != nil {
+			return nil, err
+		}
+		if f.Content, err = c.text(m.Keys); err != nil {
+			return nil, err
+		}
+		r.Files = append(r.Files, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Files returns every file of a snippet in order, starting with the one held
+// in the snippet itself
+func (m *SnippetModel) Files(id int) ([]*models.File, error) {
+
+	stmt := `SELECT s.filename, s.language, 0 AS position, ` + contentColumns + `
+				FROM snippets s
+				INNER JOIN contents c ON c.hash = s.content_hash
+				WHERE ` + liveRevision + ` AND s.id = ?
+				UNION ALL
+				SELECT f.name, f.language, f.position, ` + contentColumns + `
+				FROM snippet_files f
+				INNER JOIN contents c ON c.hash = f.content_hash
+				WHERE f.snippet_id = ?
+				ORDER BY position`
+
+	rows, err := m.DB.Query(stmt, id, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []*models.File{}
+	for rows.Next() {
+		f := &models.File{}
+		c := &storedContent{}
+		var position int
+		err = rows.Scan(append([]interface{}{&f.Name, &f.Language, &position}, c.fields()...)...)
+		if err != nil {
+			return nil, err
+		}
+		if f.Content, err = c.text(m.Keys); err != nil {
+			return nil, err
+		}
+
+		// Files left behind by a snippet which has since gone don't count
+		if len(files) == 0 && position != 0 {
+			return nil, models.ErrNoRecord
+		}
+		files = append(files, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	return files, nil
+}
+
+// Get a specific snippet based on its id.  Like every query returning
+// snippets other than View, it leaves out their content, which can be large;
+// use Files or WriteContent for that.
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 
 	// Select SQL to retreive a row from the snippets table
-	stmt := `SELECT id, title, content, created, expires
+	stmt := `SELECT ` + snippetColumns + `
 				FROM snippets
-				WHERE expires > UTC_TIMESTAMP() AND id = ?`
+				WHERE ` + live + ` AND id = ?`
 
 	// Initialize structure to hold the returned data
 	s := &models.Snippet{}
 
 	// Use row.Scan() to copy attributes returned to their corresponding fields
-	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := m.DB.QueryRow(stmt, id).Scan(snippetFields(s)...)
 	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
 		return nil, models.ErrNoRecord
 	}
@@ -57,24 +406,731 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 	return s, nil
 }
 
-// Latest returns the 10 most recently created snippits
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+// WriteContent writes the content of a specific unexpired snippet's first
+// file to w, decompressing it as it is written rather than building a string
+// of it.  This is not streaming from the database: the driver reads the
+// whole stored row into memory first, and encrypted content is decrypted
+// whole before it is decompressed, so memory use still grows with the stored
+// size.  It returns models.ErrNoRecord, having written nothing, if there is
+// no such snippet.
+func (m *SnippetModel) WriteContent(id int, w io.Writer) error {
 
-	// Select SQL to retreive a row from the snippets table
-	stmt := `SELECT id, title, content, created, expires
+	stmt := `SELECT ` + contentColumns + `
+				FROM snippets s
+				INNER JOIN contents c ON c.hash = s.content_hash
+				WHERE ` + liveRevision + ` AND s.id = ?`
+
+	rows, err := m.DB.Query(stmt, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return models.ErrNoRecord
+	}
+
+	// RawBytes refer to the driver's buffer rather than being copied
+	var hash, body, packed, dataKey sql.RawBytes
+	c := &storedContent{}
+	if err = rows.Scan(&hash, &body, &packed, &c.keyID, &dataKey); err != nil {
+		return err
+	}
+	c.hash, c.body, c.packed, c.dataKey = hash, body, packed, dataKey
+	return c.writeTo(w, m.Keys)
+}
+
+// View retrieves a snippet for display, counting the view against its view
+// limit if it has one.  The view which reaches the limit deletes the snippet
+// along with its history, so however many people ask for it at once it is
+// shown no more than the limit.  The content of its first file is included
+// as it may not be there to retrieve afterwards.
+func (m *SnippetModel) View(id int) (*models.Snippet, error) {
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The row lock taken here makes concurrent viewers queue up behind one
+	// another; once the snippet is deleted those still waiting find nothing
+	stmt := `SELECT ` + snippetColumns + `, ` + contentColumns + `
+				FROM snippets
+				INNER JOIN contents c ON c.hash = content_hash
+				WHERE ` + live + ` AND id = ?
+				FOR UPDATE OF snippets`
+
+	s := &models.Snippet{}
+	c := &storedContent{}
+	err = tx.QueryRow(stmt, id).Scan(append(snippetFields(s), c.fields()...)...)
+	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
+		return nil, models.ErrNoRecord
+	}
+	if err != nil { // All other errors
+		return nil, err
+	}
+	if s.Content, err = c.text(m.Keys); err != nil {
+		return nil, err
+	}
+	if s.MaxViews == 0 {
+		return s, tx.Commit()
+	}
+
+	s.Views++
+	if s.ViewsLeft() > 0 {
+		_, err = tx.Exec(`UPDATE snippets SET views = views + 1 WHERE id = ?`, id)
+	} else {
+		_, err = removeSnippets(tx, `s.id = ?`, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// viewBatchSize is the number of snippets whose views are counted by each
+// statement
+const viewBatchSize = 500
+
+// AddViews adds to the number of times snippets have been viewed, given the
+// views of each by ID, in a few statements rather than one per view.  Views
+// of view-limited snippets are left alone as View counts those itself, as
+// are those of snippets which no longer exist.
+func (m *SnippetModel) AddViews(counts map[int]int) error {
+	ids := make([]int, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids) // Update rows in a consistent order to avoid deadlocks
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > viewBatchSize {
+			n = viewBatchSize
+		}
+		batch := ids[:n]
+		ids = ids[n:]
+
+		args := make([]interface{}, 0, 3*n)
+		for _, id := range batch {
+			args = append(args, id, counts[id])
+		}
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		stmt := `UPDATE snippets
+					SET views = views + CASE id` + strings.Repeat(` WHEN ? THEN ?`, n) + ` END
+					WHERE max_views = 0 AND id IN (?` + strings.Repeat(`, ?`, n-1) + `)`
+		if _, err := m.DB.Exec(stmt, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snippetChildren lists the tables whose rows belong to a snippet by their
+// snippet_id column, which are removed along with it
+var snippetChildren = []string{
+	"snippet_revisions",
+	"snippet_revision_files",
+	"snippet_files",
+	"snippet_tags",
+	"stars",
+	"comments",
+	"collection_snippets",
+}
+
+// removeSnippets permanently removes the snippets meeting a condition on
+// snippets as s, along with their content and every row belonging to them,
+// as part of a wider transaction.  It returns how many snippets were removed.
+func removeSnippets(tx *sql.Tx, where string, args ...interface{}) (int64, error) {
+
+	// Everything else goes first while the snippets can still be used to
+	// find it
+	if err := releaseContent(tx, where, args...); err != nil {
+		return 0, err
+	}
+	for _, table := range snippetChildren {
+		stmt := `DELETE x FROM ` + table + ` x
+					INNER JOIN snippets s ON s.id = x.snippet_id
+					WHERE ` + where
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE s FROM snippets s WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// BySlug gets a specific snippet based on its slug
+func (m *SnippetModel) BySlug(slug string) (*models.Snippet, error) {
+
+	stmt := `SELECT ` + snippetColumns + `
 				FROM snippets
-				WHERE expires > UTC_TIMESTAMP()
-				ORDER BY created DESC
-				LIMIT 10`
+				WHERE ` + live + ` AND slug = ?`
+
+	s := &models.Snippet{}
+	err := m.DB.QueryRow(stmt, slug).Scan(snippetFields(s)...)
+	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
+		return nil, models.ErrNoRecord
+	}
+	if err != nil { // All other errors
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Latest returns a page of the most recently created public snippets.  Like
+// the other public listings it leaves out view-limited snippets, which are
+// meant only for those they are shared with.
+func (m *SnippetModel) Latest(page models.Page) ([]*models.Snippet, error) {
+
+	// Select SQL to retreive rows from the snippets table.  The page's
+	// position and ordering are added by listPage.
+	stmt := `SELECT ` + snippetColumns + `
+				FROM snippets
+				WHERE ` + live + ` AND visibility = 'public' AND max_views = 0`
+
+	return m.listPage(stmt, nil, page)
+}
+
+// ByUser returns a page of the unexpired snippets owned by a specific user
+// with the most recently created first
+func (m *SnippetModel) ByUser(userID int, page models.Page) ([]*models.Snippet, error) {
+
+	// Select SQL to retreive the rows owned by the user from the snippets table
+	stmt := `SELECT ` + snippetColumns + `
+				FROM snippets
+				WHERE ` + live + ` AND user_id = ?`
+
+	return m.listPage(stmt, []interface{}{userID}, page)
+}
+
+// ByTag returns a page of the unexpired public snippets with a specific tag
+// with the most recently created first
+func (m *SnippetModel) ByTag(tag string, page models.Page) ([]*models.Snippet, error) {
+
+	// Select SQL to retreive the rows with the tag from the snippets table
+	stmt := `SELECT ` + snippetColumns + `
+				FROM snippets
+				WHERE ` + live + ` AND visibility = 'public' AND max_views = 0 AND id IN (
+					SELECT st.snippet_id
+					FROM snippet_tags st
+					INNER JOIN tags t ON t.id = st.tag_id
+					WHERE t.name = ?
+				)`
+
+	return m.listPage(stmt, []interface{}{strings.ToLower(tag)}, page)
+}
+
+// Starred returns a page of the unexpired snippets starred by a specific user
+// which they can still see, with the most recently created first.  Snippets
+// made private or view-limited by someone else since are left out.
+func (m *SnippetModel) Starred(userID int, page models.Page) ([]*models.Snippet, error) {
+
+	stmt := `SELECT ` + snippetColumns + `
+				FROM snippets
+				WHERE ` + live + `
+					AND ((visibility != 'private' AND max_views = 0) OR user_id = ?)
+					AND id IN (SELECT snippet_id FROM stars WHERE user_id = ?)`
+
+	return m.listPage(stmt, []interface{}{userID, userID}, page)
+}
+
+// InCollection returns a page of the unexpired snippets in a collection which
+// a specific user can see, with the most recently created first.  Sharing a
+// collection does not share the private or view-limited snippets in it.
+func (m *SnippetModel) InCollection(collectionID, userID int, page models.Page) ([]*models.Snippet, error) {
+
+	stmt := `SELECT ` + snippetColumns + `
+				FROM snippets
+				WHERE ` + live + `
+					AND ((visibility != 'private' AND max_views = 0) OR user_id = ?)
+					AND id IN (SELECT snippet_id FROM collection_snippets WHERE collection_id = ?)`
+
+	return m.listPage(stmt, []interface{}{userID, collectionID}, page)
+}
+
+// Forks returns the unexpired snippets forked from a specific snippet which
+// are either publicly listed or owned by the given user, with the most
+// recently created first
+func (m *SnippetModel) Forks(id, userID int) ([]*models.Snippet, error) {
+
+	stmt := `SELECT ` + snippetColumns + `
+				FROM snippets
+				WHERE ` + live + ` AND parent_id = ?
+					AND ((visibility = 'public' AND max_views = 0) OR user_id = ?)
+				ORDER BY created DESC, id DESC`
+
+	rows, err := m.DB.Query(stmt, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSnippets(rows)
+}
+
+// Identical returns the number of other unexpired snippets with the same
+// content as a specific snippet which are either publicly listed or owned by
+// the given user.  Others are not counted lest the count reveal that someone
+// has privately saved some content.
+func (m *SnippetModel) Identical(id, userID int) (int, error) {
+
+	stmt := `SELECT COUNT(*)
+				FROM snippets
+				WHERE ` + live + ` AND id != ?
+					AND ((visibility = 'public' AND max_views = 0) OR user_id = ?)
+					AND content_hash = (SELECT content_hash FROM snippets WHERE id = ?)`
+
+	var n int
+	err := m.DB.QueryRow(stmt, id, userID, id).Scan(&n)
+	return n, err
+}
+
+// listPage completes a snippets query by restricting it to the rows on one
+// side of the page's cursor (keyset pagination) and ordering it by creation
+// time.  Unlike LIMIT/OFFSET paging this stays fast however far back a
+// listing goes and does not skip or repeat rows as new snippets are added.
+func (m *SnippetModel) listPage(stmt string, args []interface{}, page models.Page) ([]*models.Snippet, error) {
+
+	// Rows newer than a cursor are fetched oldest first so the LIMIT keeps
+	// those closest to the cursor, then reversed below
+	order := "DESC"
+	switch {
+	case page.Before != nil:
+		stmt += ` AND (created < ? OR (created = ? AND id < ?))`
+		args = append(args, page.Before.Created, page.Before.Created, page.Before.ID)
+	case page.After != nil:
+		stmt += ` AND (created > ? OR (created = ? AND id > ?))`
+		args = append(args, page.After.Created, page.After.Created, page.After.ID)
+		order = "ASC"
+	}
+	stmt += ` ORDER BY created ` + order + `, id ` + order + ` LIMIT ?`
+	args = append(args, page.Limit)
 
 	// Use Query() on the connection pool to to execute our query and
 	// return a set of records in sql.Rows
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets, err := scanSnippets(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if page.After != nil {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
+
+	return snippets, nil
+}
+
+// Search returns the unexpired public snippets matching the query's terms in
+// their title or content, most relevant first, or all of the viewer's own
+// matching snippets when the search is restricted to them.  The content returned is that
+// which is searched, which for long content is only its start and for
+// encrypted content is nothing, so only its title is matched.
+func (m *SnippetModel) Search(q models.SearchQuery) ([]*models.Snippet, error) {
+
+	// Build the filter clauses for the optional criteria.  Each MATCH()
+	// expression must repeat a full-text index's columns exactly, and the
+	// titles and contents are indexed in their own tables.
+	where := []string{
+		live,
+		"(MATCH(title) AGAINST(? IN NATURAL LANGUAGE MODE) OR MATCH(body) AGAINST(? IN NATURAL LANGUAGE MODE))",
+	}
+	args := []interface{}{q.Terms, q.Terms, q.Terms, q.Terms}
+	if q.UserID == 0 || q.UserID != q.ViewerID {
+		where = append(where, "visibility = 'public'", "max_views = 0")
+	}
+	if q.UserID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, q.CreatedBefore)
+	}
+	args = append(args, q.Limit, q.Offset)
+
+	stmt := `SELECT ` + snippetColumns + `, body,
+				MATCH(title) AGAINST(? IN NATURAL LANGUAGE MODE) + MATCH(body) AGAINST(? IN NATURAL LANGUAGE MODE) AS relevance
+				FROM snippets
+				INNER JOIN contents ON hash = content_hash
+				WHERE ` + strings.Join(where, " AND ") + `
+				ORDER BY relevance DESC, created DESC
+				LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s := &models.Snippet{}
+		var relevance float64
+		err = rows.Scan(append(snippetFields(s), &s.Content, &relevance)...)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Delete moves a snippet owned by the given user into the trash.  Trashed
+// snippets are hidden from every other query until they are either restored
+// or purged.
+func (m *SnippetModel) Delete(id, userID int) error {
+	stmt := `UPDATE snippets SET deleted_at = UTC_TIMESTAMP()
+				WHERE deleted_at IS NULL AND id = ? AND user_id = ?`
+	return execOne(m.DB, stmt, id, userID)
+}
+
+// Restore takes a snippet owned by the given user back out of the trash
+func (m *SnippetModel) Restore(id, userID int) error {
+	stmt := `UPDATE snippets SET deleted_at = NULL
+				WHERE deleted_at IS NOT NULL AND id = ? AND user_id = ?`
+	return execOne(m.DB, stmt, id, userID)
+}
+
+// Trash returns the snippets owned by a user which are in the trash with the
+// most recently deleted first
+func (m *SnippetModel) Trash(userID int) ([]*models.Snippet, error) {
+
+	stmt := `SELECT ` + snippetColumns + `, deleted_at
+				FROM snippets
+				WHERE deleted_at IS NOT NULL AND user_id = ?
+				ORDER BY deleted_at DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s := &models.Snippet{}
+		var deleted sql.NullTime
+		err = rows.Scan(append(snippetFields(s), &deleted)...)
+		if err != nil {
+			return nil, err
+		}
+		s.Deleted = deleted.Time
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Purge permanently removes a trashed snippet owned by the given user along
+// with all of its revisions
+func (m *SnippetModel) Purge(id, userID int) error {
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	n, err := removeSnippets(tx, `s.deleted_at IS NOT NULL AND s.id = ? AND s.user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return tx.Commit()
+}
+
+// PurgeTrash permanently removes every snippet which has been in the trash
+// for longer than the retention period and returns how many were removed
+func (m *SnippetModel) PurgeTrash(retention time.Duration) (int, error) {
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	seconds := int64(retention / time.Second)
+
+	n, err := removeSnippets(tx, `s.deleted_at < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)`, seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// ReapExpired permanently removes up to limit snippets which have expired,
+// along with their revisions and tags, leaving a tombstone for each.  It
+// returns how many were removed; a full batch suggests there may be more.
+//
+// Rows already locked by another instance doing the same are skipped, so
+// several instances can reap concurrently without waiting on or duplicating
+// each other's work.
+func (m *SnippetModel) ReapExpired(limit int) (int, error) {
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT id, user_id, slug, visibility, expires
+				FROM snippets
+				WHERE expires <= UTC_TIMESTAMP() AND deleted_at IS NULL
+				ORDER BY expires
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.Query(stmt, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []interface{}
+	var placeholders []string
+	var tombstones []*models.Tombstone
+	for rows.Next() {
+		t := &models.Tombstone{}
+		err = rows.Scan(&t.SnippetID, &t.UserID, &t.Slug, &t.Visibility, &t.Expired)
+		if err != nil {
+			return 0, err
+		}
+		tombstones = append(tombstones, t)
+		ids = append(ids, t.SnippetID)
+		placeholders = append(placeholders, "?")
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	if len(tombstones) == 0 {
+		return 0, nil
+	}
+
+	for _, t := range tombstones {
+		stmt = `INSERT IGNORE INTO snippet_tombstones (snippet_id, user_id, slug, visibility, expired, reaped)
+					VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+		if _, err = tx.Exec(stmt, t.SnippetID, t.UserID, t.Slug, t.Visibility, t.Expired); err != nil {
+			return 0, err
+		}
+	}
+
+	in := "(" + strings.Join(placeholders, ", ") + ")"
+	if _, err = removeSnippets(tx, `s.id IN `+in, ids...); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(tombstones), nil
+}
+
+// Reencrypt brings up to limit rows of content not yet under the keyring's
+// current master key under it, returning how many it changed; a full batch
+// suggests there may be more.  Content encrypted under an older key only has
+// its data key rewrapped, while unencrypted content is encrypted and moved,
+// along with every reference to it, from its plain hash to its keyed one.
+//
+// Run in batches until none are left after rotating in a new master key, this
+// lets the old key be retired, or after first configuring a keyring, it
+// encrypts content stored before.  Every instance reading content must
+// already have the new keyring.  Rows already locked by another instance are
+// skipped, as by ReapExpired, so it can run alongside the site.
+func (m *SnippetModel) Reencrypt(limit int) (int, error) {
+
+	if m.Keys == nil {
+		return 0, errNoKeyring
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT ` + contentColumns + `, c.refs
+				FROM contents c
+				WHERE c.key_id IS NULL OR c.key_id <> ?
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.Query(stmt, m.Keys.Current(), limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var contents []*storedContent
+	var refs []int
+	for rows.Next() {
+		c := &storedContent{}
+		var n int
+		if err = rows.Scan(append(c.fields(), &n)...); err != nil {
+			return 0, err
+		}
+		contents = append(contents, c)
+		refs = append(refs, n)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for i, c := range contents {
+		if c.keyID.Valid {
+			keyID, dataKey, err := m.Keys.Rewrap(c.keyID.String, c.dataKey)
+			if err != nil {
+				return 0, err
+			}
+			stmt = `UPDATE contents SET key_id = ?, data_key = ? WHERE hash = ?`
+			if _, err = tx.Exec(stmt, keyID, dataKey, c.hash); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// The same content may already be stored encrypted, in which case
+		// the references are merged into it
+		content, err := c.text(nil)
+		if err != nil {
+			return 0, err
+		}
+		e, err := newStoredContent(m.Keys, content)
+		if err != nil {
+			return 0, err
+		}
+		stmt = `INSERT INTO contents (hash, body, packed, key_id, data_key, refs) VALUES (?, ?, ?, ?, ?, ?)
+					ON DUPLICATE KEY UPDATE refs = refs + ?`
+		_, err = tx.Exec(stmt, e.hash, string(e.body), e.packed, e.keyID, e.dataKey, refs[i], refs[i])
+		if err != nil {
+			return 0, err
+		}
+		for _, table := range []string{"snippets", "snippet_files", "snippet_revisions", "snippet_revision_files"} {
+			stmt = `UPDATE ` + table + ` SET content_hash = ? WHERE content_hash = ?`
+			if _, err = tx.Exec(stmt, e.hash, c.hash); err != nil {
+				return 0, err
+			}
+		}
+		if _, err = tx.Exec(`DELETE FROM contents WHERE hash = ?`, c.hash); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(contents), nil
+}
+
+// Tombstone returns the record of an expired snippet given its id.  Expired
+// snippets which have yet to be reaped are included.
+func (m *SnippetModel) Tombstone(id int) (*models.Tombstone, error) {
+	return m.tombstone("snippet_id", "id", id)
+}
+
+// TombstoneBySlug returns the record of an expired snippet given its slug
+func (m *SnippetModel) TombstoneBySlug(slug string) (*models.Tombstone, error) {
+	return m.tombstone("slug", "slug", slug)
+}
+
+// tombstone looks up an expired snippet, reaped or not, using the given
+// columns of the snippet_tombstones and snippets tables
+func (m *SnippetModel) tombstone(tombstoneColumn, snippetColumn string, value interface{}) (*models.Tombstone, error) {
+
+	stmt := `SELECT snippet_id, user_id, slug, visibility, expired
+				FROM snippet_tombstones
+				WHERE ` + tombstoneColumn + ` = ?
+			UNION ALL
+			SELECT id, user_id, slug, visibility, expires
+				FROM snippets
+				WHERE expires <= UTC_TIMESTAMP() AND deleted_at IS NULL AND ` + snippetColumn + ` = ?
+			LIMIT 1`
+
+	t := &models.Tombstone{}
+	err := m.DB.QueryRow(stmt, value, value).Scan(&t.SnippetID, &t.UserID, &t.Slug, &t.Visibility, &t.Expired)
+	if err != nil && errors.Is(err, sql.ErrNoRows) { // No records error
+		return nil, models.ErrNoRecord
+	}
+	if err != nil { // All other errors
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}
+
+// execOne executes a statement which is expected to change exactly one row,
+// returning models.ErrNoRecord if no row matched
+func execOne(db execer, stmt string, args ...interface{}) error {
+	result, err := db.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// scanSnippets copies each row of a snippets query result set into a slice of
+// snippet models.  The caller remains responsible for closing the rows.
+func scanSnippets(rows *sql.Rows) ([]*models.Snippet, error) {
+
 	// Initialize structure to hold the returned data set
 	snippets := []*models.Snippet{}
 
@@ -84,7 +1140,7 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 		s := &models.Snippet{}
 
 		// Use row.Scan() to copy attributes from returned record
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err := rows.Scan(snippetFields(s)...)
 		if err != nil {
 			return nil, err
 		}
@@ -94,7 +1150,7 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	}
 
 	// Check for errors
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
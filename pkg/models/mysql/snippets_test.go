@@ -0,0 +1,466 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ptodd.org/snippetbox/pkg/keyring"
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+func TestSnippetModelEncryption(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	keys, err := keyring.Parse(strings.NewReader("hash QEFCQ0RFRkdISUpLTE1OT1BRUlNUVVZXWFlaW1xdXl8=\n" +
+		"test AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := &SnippetModel{DB: db}
+	encrypted := &SnippetModel{DB: db, Keys: keys}
+
+	// The same content is stored once before a keyring is configured and
+	// again, encrypted, after
+	const content = "A frog jumps in"
+	insert := func(m *SnippetModel, title string) int {
+		files := []*models.File{{Name: "haiku.txt", Language: "text", Content: content}}
+		id, err := m.Insert(1, title, files, models.Public, time.Time{}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	before := insert(plain, "An old silent pond")
+	after := insert(encrypted, "Splash! Silence again")
+
+	sum := sha256.Sum256([]byte(content))
+	var rows int
+	if err = db.QueryRow(`SELECT COUNT(*) FROM contents`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("want 2 rows of content; got %d", rows)
+	}
+	var body string
+	err = db.QueryRow(`SELECT body FROM contents WHERE hash = ?`, keys.Hash([]byte(content))).Scan(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		t.Errorf("want no searchable body for encrypted content; got %q", body)
+	}
+
+	// Encrypted content is only found by its title
+	search := func(terms string) []*models.Snippet {
+		s, err := encrypted.Search(models.SearchQuery{Terms: terms, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if s := search("frog"); len(s) != 1 || s[0].ID != before {
+		t.Errorf("want only the unencrypted snippet found by its content; got %d results", len(s))
+	}
+	if s := search("splash"); len(s) != 1 || s[0].ID != after || s[0].Content != "" {
+		t.Errorf("want the encrypted snippet found by its title alone")
+	}
+
+	// Re-encrypting moves the unencrypted content to its keyed hash, merging
+	// it with the encrypted copy, after which nothing has the plain hash
+	n, err := encrypted.Reencrypt(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want 1 row re-encrypted; got %d", n)
+	}
+	var refs int
+	err = db.QueryRow(`SELECT COUNT(*), SUM(refs) FROM contents`).Scan(&rows, &refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 || refs != 4 {
+		t.Errorf("want 1 row of content with 4 references; got %d with %d", rows, refs)
+	}
+	err = db.QueryRow(`SELECT COUNT(*) FROM contents WHERE hash = ?`, sum[:]).Scan(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Errorf("want no content under its plain hash")
+	}
+	for _, id := range []int{before, after} {
+		files, err := encrypted.Files(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[0].Content != content {
+			t.Errorf("want content %q; got %q", content, files[0].Content)
+		}
+		var buf bytes.Buffer
+		if err = encrypted.WriteContent(id, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != content {
+			t.Errorf("want %q written; got %q", content, buf.String())
+		}
+	}
+	if s := search("frog"); len(s) != 0 {
+		t.Errorf("want no snippet found by encrypted content; got %d", len(s))
+	}
+}
+
+// insertSnippet adds a public snippet owned by the first user with a file
+// for each of contents, failing the test if it cannot
+func insertSnippet(t *testing.T, m *SnippetModel, expires time.Time, maxViews int, contents ...string) int {
+	t.Helper()
+
+	files := make([]*models.File, len(contents))
+	for i, content := range contents {
+		files[i] = &models.File{Name: fmt.Sprintf("file%d.txt", i), Language: "text", Content: content}
+	}
+	id, err := m.Insert(1, "Haiku", files, models.Public, expires, maxViews, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestSnippetModelView(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := &SnippetModel{DB: db}
+	unlimited := insertSnippet(t, m, time.Time{}, 0, "An old silent pond")
+	limited := insertSnippet(t, m, time.Time{}, 3, "A frog jumps in")
+
+	// Views of unlimited snippets are left to AddViews
+	for i := 0; i < 2; i++ {
+		s, err := m.View(unlimited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Views != 0 {
+			t.Errorf("want no views counted; got %d", s.Views)
+		}
+	}
+
+	// However many ask for a view-limited snippet at once, it is shown no
+	// more than its limit and then removed along with its content
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var shown []int
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := m.View(limited)
+			if err == models.ErrNoRecord {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if s.Content != "A frog jumps in" {
+				t.Errorf("want content %q; got %q", "A frog jumps in", s.Content)
+			}
+			mu.Lock()
+			shown = append(shown, s.Views)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(shown) != 3 {
+		t.Fatalf("want the snippet shown 3 times; got %d", len(shown))
+	}
+	seen := map[int]bool{}
+	for _, views := range shown {
+		seen[views] = true
+	}
+	for views := 1; views <= 3; views++ {
+		if !seen[views] {
+			t.Errorf("want a view numbered %d; got %v", views, shown)
+		}
+	}
+	if _, err := m.Get(limited); err != models.ErrNoRecord {
+		t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+	}
+	var rows int
+	err := db.QueryRow(`SELECT COUNT(*) FROM snippet_revisions WHERE snippet_id = ?`, limited).Scan(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Errorf("want no revisions left; got %d", rows)
+	}
+	sum := sha256.Sum256([]byte("A frog jumps in"))
+	if err = db.QueryRow(`SELECT COUNT(*) FROM contents WHERE hash = ?`, sum[:]).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Errorf("want no content left")
+	}
+}
+
+// contentRefs returns the number of references counted to some unencrypted
+// content, or -1 if it is no longer stored
+func contentRefs(t *testing.T, db *sql.DB, content string) int {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(content))
+	var refs int
+	err := db.QueryRow(`SELECT refs FROM contents WHERE hash = ?`, sum[:]).Scan(&refs)
+	if err == sql.ErrNoRows {
+		return -1
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs
+}
+
+func TestSnippetModelContentRefs(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := &SnippetModel{DB: db}
+	checkRefs := func(when string, want map[string]int) {
+		t.Helper()
+		for content, n := range want {
+			if got := contentRefs(t, db, content); got != n {
+				t.Errorf("%s: want %d references to %q; got %d", when, n, content, got)
+			}
+		}
+	}
+
+	// Each snippet, file and revision holds its own reference, even to
+	// content shared with another
+	first := insertSnippet(t, m, time.Time{}, 0, "An old silent pond", "A frog jumps in")
+	second := insertSnippet(t, m, time.Time{}, 0, "An old silent pond")
+	checkRefs("Inserted", map[string]int{"An old silent pond": 4, "A frog jumps in": 2})
+
+	// Editing moves the snippet's references to its new files while the
+	// first revision keeps those to the old
+	files := []*models.File{
+		{Name: "pond.txt", Language: "text", Content: "An old silent pond"},
+		{Name: "moon.txt", Language: "text", Content: "Harvest moon"},
+	}
+	if err := m.Update(first, 1, "Haiku", files, models.Public); err != nil {
+		t.Fatal(err)
+	}
+	checkRefs("Updated", map[string]int{"An old silent pond": 5, "A frog jumps in": 1, "Harvest moon": 2})
+
+	for number, want := range map[int][]string{1: {"An old silent pond", "A frog jumps in"}, 2: {"An old silent pond", "Harvest moon"}} {
+		r, err := m.Revision(first, number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Files) != len(want) {
+			t.Fatalf("want revision %d to have %d files; got %d", number, len(want), len(r.Files))
+		}
+		for i, f := range r.Files {
+			if f.Content != want[i] {
+				t.Errorf("want file %d of revision %d to be %q; got %q", i, number, want[i], f.Content)
+			}
+		}
+	}
+
+	// Removing the first snippet removes the content only it referenced, and
+	// the second keeps what they shared
+	if err := m.Delete(first, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Purge(first, 1); err != nil {
+		t.Fatal(err)
+	}
+	checkRefs("Purged", map[string]int{"An old silent pond": 2, "A frog jumps in": -1, "Harvest moon": -1})
+
+	f, err := m.Files(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f) != 1 || f[0].Content != "An old silent pond" {
+		t.Errorf("want the shared content still readable")
+	}
+}
+
+func TestSnippetModelReapExpired(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := &SnippetModel{DB: db}
+	expired := time.Now().Add(-time.Hour)
+	reaped := insertSnippet(t, m, expired, 0, "An old silent pond")
+	locked := insertSnippet(t, m, expired.Add(time.Minute), 0, "A frog jumps in")
+	live := insertSnippet(t, m, time.Now().Add(time.Hour), 0, "Harvest moon")
+
+	// A snippet locked by another reaper is skipped rather than waited for
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var id int
+	if err = tx.QueryRow(`SELECT id FROM snippets WHERE id = ? FOR UPDATE`, locked).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := m.ReapExpired(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want 1 snippet reaped while the other is locked; got %d", n)
+	}
+	if contentRefs(t, db, "An old silent pond") != -1 {
+		t.Errorf("want the reaped snippet's content removed")
+	}
+
+	// Both expired snippets have tombstones, whether or not they were reaped
+	for _, id := range []int{reaped, locked} {
+		ts, err := m.Tombstone(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts.SnippetID != id || ts.UserID != 1 || ts.Visibility != models.Public {
+			t.Errorf("want a tombstone for snippet %d; got %+v", id, ts)
+		}
+	}
+
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{1, 0} {
+		n, err = m.ReapExpired(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("want %d snippets reaped; got %d", want, n)
+		}
+	}
+	if _, err = m.Tombstone(locked); err != nil {
+		t.Errorf("want a tombstone once reaped; got %v", err)
+	}
+	if _, err = m.Tombstone(live); err != models.ErrNoRecord {
+		t.Errorf("want %v for the unexpired snippet; got %v", models.ErrNoRecord, err)
+	}
+	if _, err = m.Get(live); err != nil {
+		t.Errorf("want the unexpired snippet kept; got %v", err)
+	}
+
+	var rows int
+	stmt := `SELECT COUNT(*) FROM snippet_revisions WHERE snippet_id IN (?, ?)`
+	if err = db.QueryRow(stmt, reaped, locked).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Errorf("want no revisions of reaped snippets left; got %d", rows)
+	}
+}
+
+func TestSnippetModelLatestPages(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := &SnippetModel{DB: db}
+	var ids []int
+	for i := 0; i < 5; i++ {
+		ids = append(ids, insertSnippet(t, m, time.Time{}, 0, fmt.Sprintf("Haiku %d", i)))
+	}
+	files := []*models.File{{Language: "text", Content: "A private haiku"}}
+	private, err := m.Insert(1, "Private", files, models.Private, time.Time{}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Most share a creation time, so they are ordered by ID, but the second
+	// is the newest
+	stmt := `UPDATE snippets SET created = ? WHERE id = ?`
+	for _, id := range append(ids, private) {
+		created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		if id == ids[1] || id == private {
+			created = created.AddDate(1, 0, 0)
+		}
+		if _, err = db.Exec(stmt, created, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []int{ids[1], ids[4], ids[3], ids[2], ids[0]}
+
+	// Paging back from the newest visits each public snippet once, in
+	// order, and paging forward from the last page returns the same pages
+	var pages [][]*models.Snippet
+	page := models.Page{Limit: 2}
+	for {
+		snippets, err := m.Latest(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snippets) == 0 {
+			break
+		}
+		pages = append(pages, snippets)
+		page.Before = models.CursorFor(snippets[len(snippets)-1])
+	}
+	var got []int
+	for _, p := range pages {
+		got = append(got, snippetIDs(p)...)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("want %v; got %v", want, got)
+	}
+
+	for i := len(pages) - 1; i > 0; i-- {
+		prev, err := m.Latest(models.Page{After: models.CursorFor(pages[i][0]), Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(snippetIDs(prev)) != fmt.Sprint(snippetIDs(pages[i-1])) {
+			t.Errorf("want page %v before %v; got %v", snippetIDs(pages[i-1]), snippetIDs(pages[i]), snippetIDs(prev))
+		}
+	}
+}
+
+// snippetIDs returns the IDs of some snippets in order
+func snippetIDs(snippets []*models.Snippet) []int {
+	ids := make([]int, len(snippets))
+	for i, s := range snippets {
+		ids[i] = s.ID
+	}
+	return ids
+}
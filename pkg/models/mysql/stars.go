@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"ptodd.org/snippetbox/pkg/models"
+)
+
+// StarModel wraps a sql.DB connection pool
+type StarModel struct {
+	DB *sql.DB
+}
+
+// Add stars a snippet on behalf of a user, doing nothing if they already
+// have.  The snippet's star count is kept in step in the same transaction.
+// It returns ErrNoRecord if the snippet has expired or been deleted.
+func (m *StarModel) Add(userID, snippetID int) error {
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// A duplicate leaves the row unchanged, which is reported as no rows
+	// affected
+	stmt := `INSERT INTO stars (user_id, snippet_id, created) VALUES (?, ?, UTC_TIMESTAMP())
+				ON DUPLICATE KEY UPDATE user_id = user_id`
+	result, err := tx.Exec(stmt, userID, snippetID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// Failing to find a live snippet to count the star against rolls the
+	// star back, so none are left behind by snippets reaped in the meantime
+	stmt = `UPDATE snippets SET stars = stars + 1 WHERE ` + live + ` AND id = ?`
+	if err = execOne(tx, stmt, snippetID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Remove unstars a snippet on behalf of a user, doing nothing if they had not
+// starred it
+func (m *StarModel) Remove(userID, snippetID int) error {
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = execOne(tx, `DELETE FROM stars WHERE user_id = ? AND snippet_id = ?`, userID, snippetID)
+	if err != nil && errors.Is(err, models.ErrNoRecord) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE snippets SET stars = stars - 1 WHERE id = ? AND stars > 0`
+	if _, err = tx.Exec(stmt, snippetID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Has reports whether a user has starred a snippet
+func (m *StarModel) Has(userID, snippetID int) (bool, error) {
+
+	stmt := `SELECT EXISTS(SELECT 1 FROM stars WHERE user_id = ? AND snippet_id = ?)`
+
+	var starred bool
+	err := m.DB.QueryRow(stmt, userID, snippetID).Scan(&starred)
+	return starred, err
+}
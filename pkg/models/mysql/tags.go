@@ -0,0 +1,83 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// TagModel wraps a sql.DB connection pool
+type TagModel struct {
+	DB *sql.DB
+}
+
+// Set replaces the tags of a snippet.  Tag names are case-insensitive and are
+// stored in lower case; tags which do not exist yet are created.
+func (m *TagModel) Set(snippetID int, tags []string) error {
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Remove the existing tags before adding the new set
+	_, err = tx.Exec(`DELETE FROM snippet_tags WHERE snippet_id = ?`, snippetID)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range tags {
+
+		// Insert the tag if it is new.  Setting id to LAST_INSERT_ID(id) on
+		// a duplicate makes LastInsertId() return the existing tag's ID.
+		stmt := `INSERT INTO tags (name) VALUES (?)
+					ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`
+		result, err := tx.Exec(stmt, strings.ToLower(name))
+		if err != nil {
+			return err
+		}
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		// Associate it with the snippet.  IGNORE tolerates names which only
+		// differ by case.
+		stmt = `INSERT IGNORE INTO snippet_tags (snippet_id, tag_id) VALUES (?, ?)`
+		if _, err = tx.Exec(stmt, snippetID, tagID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ForSnippet returns the names of a snippet's tags in alphabetical order
+func (m *TagModel) ForSnippet(snippetID int) ([]string, error) {
+
+	stmt := `SELECT t.name
+				FROM tags t
+				INNER JOIN snippet_tags st ON st.tag_id = t.id
+				WHERE st.snippet_id = ?
+				ORDER BY t.name`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
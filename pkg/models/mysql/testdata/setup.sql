@@ -1,12 +1,170 @@
 CREATE TABLE snippets (
     id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    parent_id INTEGER NULL,
     title VARCHAR(100) NOT NULL,
-    content TEXT NOT NULL,
+    filename VARCHAR(100) NOT NULL DEFAULT '',
+    content_hash BINARY(32) NOT NULL,
+    language VARCHAR(20) NOT NULL DEFAULT 'text',
+    visibility VARCHAR(10) NOT NULL DEFAULT 'public',
+    slug CHAR(22) NOT NULL,
+    max_views INTEGER NOT NULL DEFAULT 0,
+    views INTEGER NOT NULL DEFAULT 0,
+    stars INTEGER NOT NULL DEFAULT 0,
+    revision INTEGER NOT NULL DEFAULT 1,
     created DATETIME NOT NULL,
-    expires DATETIME NOT NULL
+    expires DATETIME NULL,
+    deleted_at DATETIME NULL
 );
 
+CREATE INDEX idx_snippets_content_hash ON snippets(content_hash);
 CREATE INDEX idx_snippets_created ON snippets(created);
+CREATE INDEX idx_snippets_deleted_at ON snippets(deleted_at);
+CREATE INDEX idx_snippets_expires ON snippets(expires);
+CREATE FULLTEXT INDEX idx_snippets_fulltext ON snippets(title);
+CREATE INDEX idx_snippets_parent_id ON snippets(parent_id);
+CREATE INDEX idx_snippets_user_id ON snippets(user_id);
+CREATE INDEX idx_snippets_visibility_created ON snippets(visibility, created);
+
+ALTER TABLE snippets ADD CONSTRAINT snippets_uc_slug UNIQUE (slug);
+
+CREATE TABLE snippet_revisions (
+    snippet_id INTEGER NOT NULL,
+    revision INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    filename VARCHAR(100) NOT NULL DEFAULT '',
+    language VARCHAR(20) NOT NULL DEFAULT 'text',
+    content_hash BINARY(32) NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (snippet_id, revision)
+);
+
+CREATE INDEX idx_snippet_revisions_content_hash ON snippet_revisions(content_hash);
+
+CREATE TABLE snippet_revision_files (
+    snippet_id INTEGER NOT NULL,
+    revision INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    language VARCHAR(20) NOT NULL DEFAULT 'text',
+    content_hash BINARY(32) NOT NULL,
+    PRIMARY KEY (snippet_id, revision, position)
+);
+
+CREATE INDEX idx_snippet_revision_files_content_hash ON snippet_revision_files(content_hash);
+
+CREATE TABLE contents (
+    hash BINARY(32) NOT NULL PRIMARY KEY,
+    body TEXT NOT NULL,
+    packed MEDIUMBLOB NULL,
+    key_id VARCHAR(32) NULL,
+    data_key VARBINARY(60) NULL,
+    refs INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE FULLTEXT INDEX idx_contents_fulltext ON contents(body);
+CREATE INDEX idx_contents_key_id ON contents(key_id);
+
+CREATE TABLE snippet_files (
+    snippet_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    language VARCHAR(20) NOT NULL DEFAULT 'text',
+    content_hash BINARY(32) NOT NULL,
+    PRIMARY KEY (snippet_id, position)
+);
+
+CREATE INDEX idx_snippet_files_content_hash ON snippet_files(content_hash);
+
+CREATE TABLE snippet_attachments (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    blob_key VARCHAR(128) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_snippet_attachments_snippet_id ON snippet_attachments(snippet_id);
+CREATE INDEX idx_snippet_attachments_user_id ON snippet_attachments(user_id);
+
+ALTER TABLE snippet_attachments ADD CONSTRAINT snippet_attachments_uc_blob_key UNIQUE (blob_key);
+
+CREATE TABLE snippet_tombstones (
+    snippet_id INTEGER NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    slug CHAR(22) NOT NULL,
+    visibility VARCHAR(10) NOT NULL,
+    expired DATETIME NOT NULL,
+    reaped DATETIME NOT NULL
+);
+
+ALTER TABLE snippet_tombstones ADD CONSTRAINT snippet_tombstones_uc_slug UNIQUE (slug);
+
+CREATE TABLE collections (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    visibility VARCHAR(10) NOT NULL DEFAULT 'private',
+    slug CHAR(22) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_collections_user_id ON collections(user_id);
+
+ALTER TABLE collections ADD CONSTRAINT collections_uc_slug UNIQUE (slug);
+
+CREATE TABLE collection_snippets (
+    collection_id INTEGER NOT NULL,
+    snippet_id INTEGER NOT NULL,
+    added DATETIME NOT NULL,
+    PRIMARY KEY (collection_id, snippet_id)
+);
+
+CREATE INDEX idx_collection_snippets_snippet_id ON collection_snippets(snippet_id);
+
+CREATE TABLE comments (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    parent_id INTEGER NULL,
+    revision INTEGER NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    first_line INTEGER NOT NULL DEFAULT 0,
+    last_line INTEGER NOT NULL DEFAULT 0,
+    body TEXT NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_comments_parent_id ON comments(parent_id);
+CREATE INDEX idx_comments_snippet_id ON comments(snippet_id);
+
+CREATE TABLE stars (
+    user_id INTEGER NOT NULL,
+    snippet_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (user_id, snippet_id)
+);
+
+CREATE INDEX idx_stars_snippet_id ON stars(snippet_id);
+
+CREATE TABLE tags (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(30) NOT NULL
+);
+
+ALTER TABLE tags ADD CONSTRAINT tags_uc_name UNIQUE (name);
+
+CREATE TABLE snippet_tags (
+    snippet_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (snippet_id, tag_id)
+);
+
+CREATE INDEX idx_snippet_tags_tag_id ON snippet_tags(tag_id);
 
 CREATE TABLE users (
     id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
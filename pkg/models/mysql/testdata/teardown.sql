@@ -1,3 +1,27 @@
 DROP TABLE users;
 
+DROP TABLE stars;
+
+DROP TABLE comments;
+
+DROP TABLE collection_snippets;
+
+DROP TABLE collections;
+
+DROP TABLE snippet_tags;
+
+DROP TABLE tags;
+
+DROP TABLE snippet_tombstones;
+
+DROP TABLE snippet_attachments;
+
+DROP TABLE snippet_files;
+
+DROP TABLE contents;
+
+DROP TABLE snippet_revision_files;
+
+DROP TABLE snippet_revisions;
+
 DROP TABLE snippets;
\ No newline at end of file
@@ -0,0 +1,257 @@
+// Package sanitize cleans untrusted HTML using an allowlist of elements and
+// attributes so it can be safely included in a page.  Anything which is not
+// explicitly allowed is removed: disallowed elements are dropped but their
+// text is kept, except for elements such as <script> whose content is
+// dropped as well.  URLs are restricted to safe schemes.
+//
+// c.f. https://cheatsheetseries.owasp.org/cheatsheets/Cross_Site_Scripting_Prevention_Cheat_Sheet.html
+
+package sanitize
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// allowed maps each permitted element to its permitted attributes
+var allowed = map[string][]string{
+	"a":          {"href", "title", "class"},
+	"abbr":       {"title"},
+	"b":          nil,
+	"blockquote": nil,
+	"br":         nil,
+	"code":       {"class"},
+	"dd":         nil,
+	"del":        nil,
+	"details":    nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"h1":         {"id"},
+	"h2":         {"id"},
+	"h3":         {"id"},
+	"h4":         {"id"},
+	"h5":         {"id"},
+	"h6":         {"id"},
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        nil,
+	"kbd":        nil,
+	"li":         nil,
+	"mark":       nil,
+	"ol":         {"start"},
+	"p":          nil,
+	"pre":        nil,
+	"q":          nil,
+	"s":          nil,
+	"samp":       nil,
+	"span":       {"class"},
+	"strong":     nil,
+	"sub":        nil,
+	"summary":    nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"align"},
+	"tfoot":      nil,
+	"th":         {"align"},
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+// dropContent lists the elements which are removed along with everything
+// inside them
+var dropContent = map[string]bool{
+	"iframe":    true,
+	"math":      true,
+	"noembed":   true,
+	"noframes":  true,
+	"noscript":  true,
+	"object":    true,
+	"plaintext": true,
+	"script":    true,
+	"style":     true,
+	"svg":       true,
+	"template":  true,
+	"textarea":  true,
+	"title":     true,
+	"xmp":       true,
+}
+
+// void lists the permitted elements which never have content or an end tag
+var void = map[string]bool{
+	"br":  true,
+	"hr":  true,
+	"img": true,
+}
+
+// Attribute value patterns
+var (
+	classRX  = regexp.MustCompile(`^(?:hl-[a-z]+|language-[a-z0-9+-]+|anchor)$`)
+	idRX     = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	numberRX = regexp.MustCompile(`^\d{1,5}$`)
+	alignRX  = regexp.MustCompile(`^(?:left|center|right)$`)
+)
+
+// HTML returns the untrusted HTML fragment with everything not on the
+// allowlist removed.  Unclosed elements are closed and stray end tags dropped
+// so the result cannot affect the markup around it.
+func HTML(s string) string {
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+
+	var sb strings.Builder
+	var open []string // stack of elements which have been opened
+	skipping := ""    // element whose content is being dropped
+	depth := 0        // nesting of the skipped element within itself
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case xhtml.ErrorToken:
+			// The end of the input; close anything left open
+			for i := len(open) - 1; i >= 0; i-- {
+				sb.WriteString("</" + open[i] + ">")
+			}
+			return sb.String()
+
+		case xhtml.TextToken:
+			if skipping == "" {
+				sb.WriteString(html.EscapeString(string(z.Text())))
+			}
+
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			t := z.Token()
+			if skipping != "" {
+				if t.Data == skipping && tt == xhtml.StartTagToken {
+					depth++
+				}
+				continue
+			}
+			if dropContent[t.Data] {
+				if tt == xhtml.StartTagToken {
+					skipping, depth = t.Data, 1
+				}
+				continue
+			}
+			if _, ok := allowed[t.Data]; !ok {
+				continue
+			}
+			sb.WriteString("<" + t.Data + attributes(t) + ">")
+			switch {
+			case void[t.Data]:
+			case tt == xhtml.SelfClosingTagToken:
+				sb.WriteString("</" + t.Data + ">")
+			default:
+				open = append(open, t.Data)
+			}
+
+		case xhtml.EndTagToken:
+			name, _ := z.TagName()
+			if skipping != "" {
+				if string(name) == skipping {
+					depth--
+					if depth == 0 {
+						skipping = ""
+					}
+				}
+				continue
+			}
+
+			// Close the most recently opened matching element along with
+			// any elements still open inside it
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == string(name) {
+					for j := len(open) - 1; j >= i; j-- {
+						sb.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+}
+
+// attributes returns the permitted attributes of a start tag rendered for
+// output, each preceded by a space
+func attributes(t xhtml.Token) string {
+	var sb strings.Builder
+	external := false
+
+	for _, a := range t.Attr {
+		if !permitted(t.Data, a.Key) {
+			continue
+		}
+		v := strings.TrimSpace(a.Val)
+		ok := false
+		switch a.Key {
+		case "href":
+			ok = safeURL(v, "http", "https", "mailto")
+			external = ok && strings.Contains(v, ":")
+		case "src":
+			ok = safeURL(v, "http", "https")
+		case "class":
+			var classes []string
+			for _, c := range strings.Fields(v) {
+				if classRX.MatchString(c) {
+					classes = append(classes, c)
+				}
+			}
+			v = strings.Join(classes, " ")
+			ok = v != ""
+		case "id":
+			ok = idRX.MatchString(v)
+		case "width", "height", "start":
+			ok = numberRX.MatchString(v)
+		case "align":
+			ok = alignRX.MatchString(v)
+		default:
+			ok = true
+		}
+		if ok {
+			sb.WriteString(" " + a.Key + "=\"" + html.EscapeString(v) + "\"")
+		}
+	}
+
+	// Don't pass on the page's reputation or a handle to the window to
+	// links leaving the site
+	if external {
+		sb.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+
+	return sb.String()
+}
+
+// permitted reports whether an element may carry an attribute
+func permitted(element, attr string) bool {
+	for _, a := range allowed[element] {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL reports whether a URL is relative or uses one of the schemes given
+func safeURL(v string, schemes ...string) bool {
+	u, err := url.Parse(v)
+	if err != nil { // includes URLs containing control characters
+		return false
+	}
+	if u.Scheme == "" {
+		return true
+	}
+	for _, s := range schemes {
+		if strings.EqualFold(u.Scheme, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package sanitize
+
+import (
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"Plain text", "a < b & c", "a &lt; b &amp; c"},
+		{"Allowed markup", "<p>Hi <em>there</em></p>", "<p>Hi <em>there</em></p>"},
+		{"Script dropped with content", "a<script>alert(1)</script>b", "ab"},
+		{"Nested dropped content", "<svg><svg></svg><script>x</script></svg>ok", "ok"},
+		{"Unknown element keeps text", "<blink>hi</blink>", "hi"},
+		{"Event handler removed", `<img src="x.png" onerror="alert(1)">`, `<img src="x.png">`},
+		{"Script URL removed", `<a href="javascript:alert(1)">x</a>`, "<a>x</a>"},
+		{"Encoded script URL removed", `<a href="&#106;avascript:alert(1)">x</a>`, "<a>x</a>"},
+		{"Control characters in URL", "<a href=\"java\tscript:alert(1)\">x</a>", "<a>x</a>"},
+		{"Data image removed", `<img src="data:image/png;base64,AAAA">`, "<img>"},
+		{"External link", `<a href="https://example.com" title="E">x</a>`, `<a href="https://example.com" title="E" rel="nofollow noopener noreferrer">x</a>`},
+		{"Relative link", `<a href="#intro">x</a>`, `<a href="#intro">x</a>`},
+		{"Class filtered", `<span class="hl-kw evil">x</span>`, `<span class="hl-kw">x</span>`},
+		{"Style removed", `<p style="position:fixed">x</p>`, "<p>x</p>"},
+		{"Unclosed element closed", "<strong>x", "<strong>x</strong>"},
+		{"Stray end tag dropped", "x</div></p>", "x"},
+		{"Misnested elements", "<em><strong>x</em>y</strong>", "<em><strong>x</strong></em>y"},
+		{"Comment removed", "a<!-- <script> -->b", "ab"},
+		{"Attribute breakout", `<a title='"><script>x</script>'>y</a>`, `<a title="&#34;&gt;&lt;script&gt;x&lt;/script&gt;">y</a>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTML(tt.in); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
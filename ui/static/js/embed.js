@@ -0,0 +1,37 @@
+// Embeds snippets in other sites.  Each script tag loading this file with a
+// data-snippet attribute giving the path of a snippet is replaced by a frame
+// showing it, e.g.
+//
+// <script src="https://snippets.example.com/static/js/embed.js" data-snippet="/snippet/1" async></script>
+(function() {
+	var scripts = document.querySelectorAll("script[data-snippet]");
+	for (var i = 0; i < scripts.length; i++) {
+		var script = scripts[i];
+		var origin = new URL(script.src, window.location.href).origin;
+		var frame = document.createElement("iframe");
+		frame.src = origin + script.getAttribute("data-snippet") + "/embed";
+		frame.title = "Snippet";
+		frame.style.width = "100%";
+		frame.style.border = "none";
+		frame.setAttribute("loading", "lazy");
+		script.parentNode.replaceChild(frame, script);
+	}
+
+	// The frames report the height of their content so they can be sized to
+	// fit it, as the host page cannot look inside them
+	if (window.snippetboxEmbeds) {
+		return;
+	}
+	window.snippetboxEmbeds = true;
+	window.addEventListener("message", function(event) {
+		if (!event.data || typeof event.data.snippetboxHeight != "number") {
+			return;
+		}
+		var frames = document.querySelectorAll("iframe");
+		for (var i = 0; i < frames.length; i++) {
+			if (frames[i].contentWindow === event.source) {
+				frames[i].style.height = event.data.snippetboxHeight + "px";
+			}
+		}
+	});
+})();
\ No newline at end of file
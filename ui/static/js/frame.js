@@ -0,0 +1,9 @@
+// Tells the page framing an embedded snippet how tall it is, so the frame can
+// be sized to fit; see embed.js
+function reportHeight() {
+	if (window.parent !== window) {
+		window.parent.postMessage({snippetboxHeight: document.documentElement.scrollHeight}, "*");
+	}
+}
+window.addEventListener("load", reportHeight);
+window.addEventListener("resize", reportHeight);
\ No newline at end of file